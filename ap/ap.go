@@ -1,9 +1,10 @@
 package ap
 
 import (
+	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,6 +13,10 @@ import (
 	gossh "golang.org/x/crypto/ssh"
 )
 
+// drainPollInterval is the interval of `drain` requests while the server
+// has active connections. See Ap.Drain.
+const drainPollInterval = time.Second
+
 type Ap struct {
 	ID         string
 	ServerAddr string
@@ -19,9 +24,18 @@ type Ap struct {
 	KeyFile    string
 	Version    *common.Version
 	Services   map[string]*Service
+	// HostKeyCallback verifies the server host key. If nil, uses the
+	// default common.HostKeyConfig callback.
+	HostKeyCallback gossh.HostKeyCallback
+	// EmbeddedSSH reports to server that the ssh service is the embedded SSH
+	// server. See common.EmbeddedSSHRequestType.
+	EmbeddedSSH bool
 
 	client *gossh.Client
 	closed bool
+	// dialStatus is true if the server reads the backend dial status of load
+	// balancer entry points. See common.DialStatusRequestType.
+	dialStatus bool
 	sync.Mutex
 	reconnectingMux sync.Mutex
 
@@ -53,8 +67,8 @@ func (c *Ap) Close() error {
 	}
 
 	c.closed = true
-	if c.client != nil {
-		return c.client.Close()
+	if client := c.getClient(); client != nil {
+		return client.Close()
 	}
 
 	for _, sl := range c.Services {
@@ -64,9 +78,50 @@ func (c *Ap) Close() error {
 	return c.delayer.Close()
 }
 
+// Drain asks the server to stop routing new load balancer connections to this
+// AP and waits for the active connections to finish or the timeout. Returns
+// the connections count still active. Call it before Close to restart the AP
+// without breaking the clients.
+func (c *Ap) Drain(timeout time.Duration) (active int, err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		client := c.getClient()
+		if client == nil {
+			return 0, nil
+		}
+		ok, payload, err := client.SendRequest(common.DrainRequestType, true,
+			gossh.Marshal(&common.DrainRequest{Timeout: uint32(timeout / time.Second)}))
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, fmt.Errorf("drain rejected: %s", string(payload))
+		}
+		var reply common.DrainReply
+		if err = gossh.Unmarshal(payload, &reply); err != nil {
+			return 0, fmt.Errorf("bad drain reply: %v", err)
+		}
+		if active = int(reply.Active); active == 0 || !time.Now().Before(deadline) {
+			return active, nil
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
+
+func (c *Ap) getClient() *gossh.Client {
+	c.Lock()
+	defer c.Unlock()
+	return c.client
+}
+
+func (c *Ap) setClient(client *gossh.Client) {
+	c.Lock()
+	c.client = client
+	c.Unlock()
+}
+
 func (c *Ap) run() {
-	var err error
-	c.client, err = c.connectToHost()
+	client, err := c.connectToHost()
 
 	log.Println("#"+c.ID+" connecting to server", c.ServerAddr)
 
@@ -75,26 +130,32 @@ func (c *Ap) run() {
 		return
 	}
 	log.Println("#"+c.ID+" connected to server", c.ServerAddr)
+	c.setClient(client)
 
 	if c.Version != nil {
-		c.client.SendRequest("ap-version", false, []byte(c.Version.ToString()))
+		client.SendRequest("ap-version", false, []byte(c.Version.ToString()))
 	}
+	if c.EmbeddedSSH {
+		client.SendRequest(common.EmbeddedSSHRequestType, false, nil)
+	}
+	ok, payload, _ := client.SendRequest(common.DialStatusRequestType, true, nil)
+	c.dialStatus = ok && string(payload) == common.DialStatusRequestType
 
 	defer func() {
 		c.registered = map[string]*ServiceListener{}
 	}()
 
 	go func() {
-		if err := c.client.Wait(); err != nil && err != io.EOF {
+		if err := client.Wait(); err != nil && err != io.EOF {
 			log.Println("#"+c.ID+" client closed with error: ", err)
 		} else {
 			log.Println("#" + c.ID + " client closed")
 		}
-		c.client = nil
+		c.setClient(nil)
 	}()
 
 	go func() {
-		for c.client != nil && !c.closed {
+		for c.getClient() != nil && !c.closed {
 			for name, sl := range c.Services {
 				if _, ok := c.registered[name]; ok {
 					continue
@@ -102,12 +163,12 @@ func (c *Ap) run() {
 
 				do := func(sl *Service) (*ServiceListener, bool) {
 					log.Println("#" + c.ID + " {" + name + "} remote listen")
-					ln, err := c.client.Listen("unix", sl.Name)
+					ln, err := client.Listen("unix", sl.Name)
 					if err != nil {
 						log.Println("#"+c.ID+" {"+name+"} remote listen failed:", err)
 						return nil, false
 					}
-					ssl := sl.Register(c.ID, ln)
+					ssl := sl.register(c.ID, ln, c.dialStatus && strings.HasPrefix(sl.Name, "*"))
 					ssl.OnClose(func() {
 						if c.registered != nil {
 							if _, ok := c.registered[name]; ok {
@@ -134,10 +195,10 @@ func (c *Ap) run() {
 		}
 	}()
 
-	for c.client != nil {
+	for c.getClient() != nil {
 		<-time.After(time.Second * 30)
-		if c.client != nil {
-			if _, _, err := c.client.SendRequest("", false, nil); err != nil && c.client != nil {
+		if c.getClient() != nil {
+			if _, _, err := client.SendRequest("", false, nil); err != nil && c.getClient() != nil {
 				log.Println("#"+c.ID+" ERROR: failed to send PING request:", err.Error())
 			}
 		}
@@ -154,20 +215,18 @@ func (c *Ap) remoteForever() {
 }
 
 func (c Ap) connectToHost() (*gossh.Client, error) {
-	buf, err := ioutil.ReadFile(common.GetKeyFile(c.KeyFile))
-	if err != nil {
-		log.Fatalf("#"+c.ID+" Load Key failed %v", err)
-	}
-	key, err := gossh.ParsePrivateKey(buf)
+	key, err := common.LoadSigner(common.GetKeyFile(c.KeyFile))
 	if err != nil {
-		log.Fatalf("#"+c.ID+" parse Key failed %v", err)
+		log.Fatalf("#"+c.ID+" %v", err)
 	}
 
 	sshConfig := &gossh.ClientConfig{
 		User: c.ApName,
 		Auth: []gossh.AuthMethod{gossh.PublicKeys(key)},
 	}
-	sshConfig.HostKeyCallback = gossh.InsecureIgnoreHostKey()
+	if sshConfig.HostKeyCallback = c.HostKeyCallback; sshConfig.HostKeyCallback == nil {
+		sshConfig.HostKeyCallback = (&common.HostKeyConfig{}).Callback()
+	}
 
 	client, err := gossh.Dial("tcp", c.ServerAddr, sshConfig)
 	if err != nil {
@@ -177,6 +236,32 @@ func (c Ap) connectToHost() (*gossh.Client, error) {
 	return client, nil
 }
 
+// Enroll binds the AP key to the AP user on server with the one-time
+// enrollment token. If the key is already enrolled, does nothing.
+func (c *Ap) Enroll(token string) (err error) {
+	var (
+		client *gossh.Client
+		s      *gossh.Session
+		out    []byte
+	)
+	if client, err = c.connectToHost(); err != nil {
+		return
+	}
+	defer client.Close()
+
+	if s, err = client.NewSession(); err != nil {
+		return
+	}
+	defer s.Close()
+
+	s.Stdin = strings.NewReader(token + "\n")
+	if out, err = s.CombinedOutput("enroll"); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	log.Println("#" + c.ID + " " + strings.TrimSpace(string(out)))
+	return nil
+}
+
 func (c *Ap) Forever() {
 	c.remoteForever()
 }
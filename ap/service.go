@@ -1,10 +1,12 @@
 package ap
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strings"
 	"sync"
 
 	"github.com/moisespsena-go/xssh/common"
@@ -12,9 +14,25 @@ import (
 
 type ServiceListener struct {
 	net.Listener
-	ID      string
-	s       *Service
-	onClose []func()
+	ID string
+	s  *Service
+	// dialStatus is true if the server reads the backend dial status. See
+	// common.DialStatusRequestType.
+	dialStatus bool
+	onClose    []func()
+}
+
+// writeDialStatus writes the backend dial result to server connection w, if
+// the server reads it. Returns true if dialErr is nil and the status was
+// written.
+func (ln *ServiceListener) writeDialStatus(prfx string, w io.Writer, dialErr error) bool {
+	if ln.dialStatus {
+		if err := common.WriteDialStatus(w, dialErr); err != nil {
+			log.Println(prfx, "write dial status failed:", err)
+			return false
+		}
+	}
+	return dialErr == nil
 }
 
 func (ln *ServiceListener) OnClose(f ...func()) *ServiceListener {
@@ -44,13 +62,17 @@ func (ln *ServiceListener) close() {
 }
 
 type Service struct {
-	Name        string
-	Addr        string
-	ForeverFunc func(sl *ServiceListener)
-	mu          sync.Mutex
-	listeners   map[string]*ServiceListener
-	lid         int
-	onClose     []func()
+	Name string
+	Addr string
+	// ProxyProtocol is the PROXY protocol version of header sent to backend
+	// with the client address received from server. If blank, the server does
+	// not send the client address.
+	ProxyProtocol string
+	ForeverFunc   func(sl *ServiceListener)
+	mu            sync.Mutex
+	listeners     map[string]*ServiceListener
+	lid           int
+	onClose       []func()
 }
 
 func (s *Service) OnClose(f ...func()) *Service {
@@ -83,12 +105,54 @@ func (s *Service) proxy(sl *ServiceListener, remoteConn net.Conn) {
 		log.Println(prfx, "closed")
 	}()
 
-	if conn, err := net.Dial("tcp", s.Addr); err != nil {
-		log.Println(prfx, "net.Dial to", s.Addr, "failed:", err)
+	if addr := strings.TrimPrefix(s.Addr, common.UDPPrefix); addr != s.Addr {
+		conn, err := net.Dial("udp", addr)
+		if !sl.writeDialStatus(prfx, remoteConn, err) {
+			if err != nil {
+				log.Println(prfx, "UDP", addr, "failed:", err)
+			} else {
+				conn.Close()
+			}
+			return
+		}
+		if err = common.ServeUDPStream(prfx, remoteConn, conn, common.UDPIdleTimeout); err != nil {
+			log.Println(prfx, "UDP", addr, "failed:", err)
+		}
+		return
+	}
+
+	var (
+		src    io.Reader = remoteConn
+		header []byte
+	)
+	if s.ProxyProtocol != "" {
+		br := bufio.NewReader(remoteConn)
+		h, err := common.ReadProxyHeader(br)
+		if err != nil {
+			log.Println(prfx, "read PROXY protocol header failed:", err)
+			return
+		}
+		log.Println(prfx, "client", h.String())
+		header, src = h.Format(s.ProxyProtocol), br
+	}
+
+	if conn, err := net.Dial("tcp", s.Addr); !sl.writeDialStatus(prfx, remoteConn, err) {
+		if err != nil {
+			log.Println(prfx, "net.Dial to", s.Addr, "failed:", err)
+		} else {
+			conn.Close()
+		}
 		return
 	} else {
+		if header != nil {
+			if _, err = conn.Write(header); err != nil {
+				conn.Close()
+				log.Println(prfx, "write PROXY protocol header failed:", err)
+				return
+			}
+		}
 		common.NewIOSync(
-			common.NewCopier(prfx+" <", conn, remoteConn),
+			common.NewCopier(prfx+" <", conn, src, conn.Close, remoteConn.Close),
 			common.NewCopier(prfx+" >", remoteConn, conn),
 		).Sync()
 	}
@@ -121,13 +185,19 @@ func (s *Service) forever(sl *ServiceListener) {
 }
 
 func (s *Service) Register(prefix string, ln net.Listener) *ServiceListener {
+	return s.register(prefix, ln, false)
+}
+
+// register registers the listener. If dialStatus is true, the backend dial
+// status is written on accepted connections.
+func (s *Service) register(prefix string, ln net.Listener, dialStatus bool) *ServiceListener {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.listeners == nil {
 		s.listeners = map[string]*ServiceListener{}
 	}
 	s.lid++
-	sl := &ServiceListener{Listener: ln, ID: prefix + "S" + fmt.Sprintf("%02d{%s}", s.lid, s.Name), s: s}
+	sl := &ServiceListener{Listener: ln, ID: prefix + "S" + fmt.Sprintf("%02d{%s}", s.lid, s.Name), s: s, dialStatus: dialStatus}
 	log.Println("[" + s.Name + "] register listener #" + sl.ID)
 	s.listeners[sl.ID] = sl
 	go s.forever(sl)
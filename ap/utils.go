@@ -2,12 +2,16 @@ package ap
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"syscall"
 	"unsafe"
+
+	"github.com/moisespsena-go/xssh/common"
 )
 
 func setWinsize(f *os.File, w, h int) {
@@ -20,12 +24,19 @@ type ServiceConfig struct {
 	SocketPath       string
 	NetAddr          string
 	ConnectionsCount int
+	// ProxyProtocol is the PROXY protocol version of header sent to backend
+	// with the client address. See common.ProxyProtocolOption.
+	ProxyProtocol string
+	// UDP is true if NetAddr is an UDP address.
+	UDP bool
 }
 
 func (cfg ServiceConfig) String() (s string) {
 	s += cfg.Name + "/"
 	if cfg.SocketPath != "" {
 		s += "unix:" + cfg.SocketPath
+	} else if cfg.UDP {
+		s += common.UDPPrefix + cfg.NetAddr
 	} else {
 		s += cfg.NetAddr
 	}
@@ -54,7 +65,27 @@ func ParseServiceDSN(dsn string) (cfg ServiceConfig, err error) {
 		return
 	}
 	cfg.Name = name
-	if strings.HasPrefix(addr, "unix:") {
+	if pos := strings.IndexByte(name, '?'); pos != -1 {
+		var options url.Values
+		if options, err = url.ParseQuery(name[pos+1:]); err != nil {
+			return cfg, fmt.Errorf("bad options: %v", err)
+		}
+		if cfg.ProxyProtocol = options.Get(common.ProxyProtocolOption); cfg.ProxyProtocol != "" {
+			if name[0] != '*' {
+				return cfg, errors.New(common.ProxyProtocolOption + " option requires load balancer entry point")
+			}
+			if err = common.ValidateProxyProtocol(cfg.ProxyProtocol); err != nil {
+				return
+			}
+		}
+	}
+	if strings.HasPrefix(addr, common.UDPPrefix) {
+		if cfg.ProxyProtocol != "" {
+			return cfg, errors.New(common.ProxyProtocolOption + " option is not supported by UDP services")
+		}
+		cfg.UDP, addr = true, strings.TrimPrefix(addr, common.UDPPrefix)
+	}
+	if strings.HasPrefix(addr, "unix:") && !cfg.UDP {
 		cfg.SocketPath = strings.TrimPrefix(addr, "unix:")
 	} else if host, port, err := net.SplitHostPort(addr); err != nil {
 		return cfg, err
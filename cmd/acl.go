@@ -0,0 +1,43 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var aclCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "Per-service access rules manager",
+	Long: `Per-service access rules manager
+
+Rules restrict which services of an access point a user may dial.
+SERVICE_PATTERN accepts shell wildcards (` + q("*") + `, ` + q("?") + `, ` + q("[...]") + `),
+e.g. ` + q("http*") + `. If user does not have rules for an access point,
+all services of it are allowed.
+`,
+}
+
+func withACL(f func(acl *server.ServiceACL) error) error {
+	return withDB(func(DB *server.DB) error {
+		return f(server.NewServiceACL(DB))
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(aclCmd)
+	aclCmd.PersistentFlags().StringVar(&dbName, "db", dbName, "SQLite 3 database file")
+}
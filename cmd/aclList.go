@@ -0,0 +1,62 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+
+	"github.com/spf13/cobra"
+)
+
+var aclListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show rules",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var filter server.ServiceACLFilter
+		if filter.User, err = cmd.Flags().GetString("user"); err != nil {
+			return
+		}
+		if filter.Ap, err = cmd.Flags().GetString("ap"); err != nil {
+			return
+		}
+		return withACL(func(acl *server.ServiceACL) error {
+			var count int
+			err := acl.List(func(i int, r *server.ServiceACLRule) error {
+				count = i
+				fmt.Fprintln(os.Stdout, i, "\t", r)
+				return nil
+			}, &filter)
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				fmt.Fprintln(os.Stdout, "No rules found.")
+			} else {
+				fmt.Fprintf(os.Stdout, "\n%d rules found.\n", count)
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	aclCmd.AddCommand(aclListCmd)
+	aclListCmd.Flags().StringP("user", "u", "", "Filter by user name")
+	aclListCmd.Flags().StringP("ap", "A", "", "Filter by access point name")
+}
@@ -22,6 +22,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/moisespsena-go/default-logger"
@@ -48,7 +49,7 @@ var apCmd = &cobra.Command{
 
 Pair or NAME/ADDR[/CONNECTION_COUNT].
 
-Only TCP connections.
+TCP connections, unix sockets and UDP datagrams.
 
 ## NAME
 
@@ -66,6 +67,17 @@ Load balancer entry point examples:
 - *SSH
 - *my_service
 
+Load balancer entry points accepts options as query string:
+- weight: the endpoint weight used by ` + q("weighted") + ` and ` + q("ip-hash") + `
+  balancers and by least connections selection. Default is 1.
+- proxy_protocol: the PROXY protocol version (` + q("v1") + ` or ` + q("v2") + `) of header
+  sent to service with the original client address. The service must accept it.
+
+Examples:
+- *http?weight=3
+- *my_service?weight=2
+- *smtp?proxy_protocol=v1
+
 ## ADDR
 
 If is unix socket path, use , other else,
@@ -79,6 +91,15 @@ Examples:
 - unix:/path/to/sockfile.sock
 - unix:/path/to/sockfile
 
+### UDP Address
+
+Format: ` + q("udp:HOST:PORT") + `. The datagrams of each client are relayed as
+a flow, closed after ` + q("--udp-idle-timeout") + ` without datagrams.
+
+Examples:
+- udp:localhost:53
+- udp:192.168.2.5:161
+
 ### Network Address
 
 The local service network address.
@@ -121,6 +142,7 @@ Default:
 - http/192.168.1.5%eth0:80
 - https/192.168.1.5:443
 - my_service/[2001:db8::1]:8080
+- *dns/udp:lo:53
 
 With connection count:
 - SSH/lo:22/6
@@ -137,6 +159,8 @@ With connection count:
 			reconnectTimeout       string
 			updateInterval         string
 			enableSSH              bool
+			enrollToken            string
+			drainTimeout           time.Duration
 		)
 
 		args = args[1:]
@@ -162,6 +186,15 @@ With connection count:
 		if updateInterval, err = cmd.Flags().GetString("update-interval"); err != nil {
 			return
 		}
+		if enrollToken, err = cmd.Flags().GetString("enroll-token"); err != nil {
+			return
+		}
+		if drainTimeout, err = cmd.Flags().GetDuration("drain-timeout"); err != nil {
+			return
+		}
+		if common.UDPIdleTimeout, err = cmd.Flags().GetDuration("udp-idle-timeout"); err != nil {
+			return
+		}
 
 		if connectionsCount < 1 {
 			connectionsCount = 1
@@ -246,10 +279,12 @@ With connection count:
 			var addr string
 			if cfg.SocketPath != "" {
 				addr = "unix:" + cfg.SocketPath
+			} else if cfg.UDP {
+				addr = common.UDPPrefix + cfg.NetAddr
 			} else {
 				addr = cfg.NetAddr
 			}
-			srvc := &ap.Service{Name: cfg.Name, Addr: addr}
+			srvc := &ap.Service{Name: cfg.Name, Addr: addr, ProxyProtocol: cfg.ProxyProtocol}
 			log.Println(fmt.Sprintf("Service `%v` -> `%s`", dsn, cfg))
 			services[cfg.Name] = srvc
 		}
@@ -266,16 +301,30 @@ With connection count:
 			}
 		}
 
+		if enrollToken != "" {
+			Ap := ap.New(user)
+			Ap.KeyFile = keyFile
+			Ap.ServerAddr = serverAddr
+			Ap.HostKeyCallback = hostKeyConfig.Callback()
+			if err = Ap.Enroll(enrollToken); err != nil {
+				return fmt.Errorf("enroll failed: %v", err)
+			}
+		}
+
 		if exe, err := os.Executable(); err == nil {
 			Version.Digest, _ = common.Digest(exe)
 		}
 		// factory and stop chan
 
 		t := task.FactoryFunc(func() task.Task {
-			done := make(chan interface{})
+			var (
+				done = make(chan interface{})
+				aps  []*ap.Ap
+			)
 			return task.NewTask(func() (err error) {
 				for i := 1; i <= maxCc; i++ {
 					Ap := ap.New(user)
+					aps = append(aps, Ap)
 					if i == 1 {
 						Ap.Version = &Version
 					}
@@ -289,6 +338,8 @@ With connection count:
 					}
 
 					Ap.KeyFile = keyFile
+					Ap.HostKeyCallback = hostKeyConfig.Callback()
+					Ap.EmbeddedSSH = enableSSH
 					Ap.ServerAddr = serverAddr
 					Ap.SetReconnectTimeout(d)
 
@@ -301,6 +352,23 @@ With connection count:
 				<-done
 				return nil
 			}, func() {
+				// drains the load balancer endpoints before close, so that
+				// the active client connections finish on this process while
+				// the new ones are routed to the other APs.
+				var wg sync.WaitGroup
+				for _, Ap := range aps {
+					wg.Add(1)
+					go func(Ap *ap.Ap) {
+						defer wg.Done()
+						if active, err := Ap.Drain(drainTimeout); err != nil {
+							log.Println("#"+Ap.ID+" drain failed:", err)
+						} else if active > 0 {
+							log.Println("#"+Ap.ID+" drain timeout:", active, "connections will be closed")
+						}
+						Ap.Close()
+					}(Ap)
+				}
+				wg.Wait()
 				close(done)
 			})
 		})
@@ -310,9 +378,10 @@ With connection count:
 			&restarts.Config{
 				FetchCronSchedule: &updateSchedule,
 				Fetcher: &updater.Fetcher{
-					ServerAddr: serverAddr,
-					KeyFile:    keyFile,
-					User:       user,
+					ServerAddr:      serverAddr,
+					KeyFile:         keyFile,
+					User:            user,
+					HostKeyCallback: hostKeyConfig.Callback(),
 				},
 			},
 		)
@@ -328,8 +397,11 @@ func init() {
 	flags.StringP("host", "H", "localhost", "SERVER_HOST: The XSSH server host.")
 	flags.IntP("connections-count", "C", 1, "Number of connections. Minimum is `1`.")
 	flags.Bool("ssh", false, "Enable embeded SSH server")
+	flags.String("enroll-token", "", "One-time enrollment token (see `xssh users enroll`) to bind the key to AP")
 	flags.StringP("server-addr", "S", common.DefaultServerAddr, "The XSSH server addr in `HOST:PORT` format.")
 	flags.StringP("reconnect-timeout", "T", defaultReconnectTimeout, reconnectTimeoutUsage)
+	flags.Duration("drain-timeout", 30*time.Second, "Max time to wait the active load balancer connections on restart (update).")
+	flags.Duration("udp-idle-timeout", common.UDPIdleTimeout, "Max time without datagrams of UDP service client flow")
 }
 
 const reconnectTimeoutUsage = `Reconnect to server timeout.
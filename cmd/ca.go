@@ -0,0 +1,44 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var caKeyFile = "xssh_ca"
+
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Certificate authority manager",
+	Long: `Certificate authority manager
+
+Issues short-lived user and access point certificates. Start the server
+with ` + q("--trusted-user-ca-keys CA_KEY_FILE.pub") + ` to accept them.
+`,
+}
+
+func withCertificates(f func(certs *server.Certificates) error) error {
+	return withDB(func(DB *server.DB) error {
+		return f(server.NewCertificates(DB))
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(caCmd)
+	caCmd.PersistentFlags().StringVar(&dbName, "db", dbName, "SQLite 3 database file")
+	caCmd.PersistentFlags().StringVar(&caKeyFile, "ca-key", caKeyFile, "CA private key file. The public key file is the same with `.pub` suffix")
+}
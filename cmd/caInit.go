@@ -0,0 +1,141 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+var caInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Generate the CA key pair",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var (
+			typ   string
+			force bool
+		)
+		if typ, err = cmd.Flags().GetString("type"); err != nil {
+			return
+		}
+		if force, err = cmd.Flags().GetBool("force"); err != nil {
+			return
+		}
+
+		if _, err = os.Stat(caKeyFile); err == nil && !force {
+			return fmt.Errorf("CA key file %q exists", caKeyFile)
+		}
+
+		var (
+			privateKey crypto.Signer
+			block      = &pem.Block{}
+		)
+
+		switch typ {
+		case "ed25519":
+			var key ed25519.PrivateKey
+			if _, key, err = ed25519.GenerateKey(rand.Reader); err != nil {
+				return
+			}
+			privateKey = key
+			if block, err = marshalED25519PrivateKey(key); err != nil {
+				return
+			}
+		case "rsa":
+			var key *rsa.PrivateKey
+			if key, err = rsa.GenerateKey(rand.Reader, 4096); err != nil {
+				return
+			}
+			privateKey = key
+			block.Type = "RSA PRIVATE KEY"
+			block.Bytes = x509.MarshalPKCS1PrivateKey(key)
+		default:
+			return fmt.Errorf("bad key type %q", typ)
+		}
+
+		publicKey, err := ssh.NewPublicKey(privateKey.Public())
+		if err != nil {
+			return
+		}
+
+		if err = ioutil.WriteFile(caKeyFile, pem.EncodeToMemory(block), 0600); err != nil {
+			return fmt.Errorf("write CA key file failed: %v", err)
+		}
+		if err = ioutil.WriteFile(caKeyFile+".pub", ssh.MarshalAuthorizedKey(publicKey), 0644); err != nil {
+			return fmt.Errorf("write CA public key file failed: %v", err)
+		}
+
+		fmt.Fprintf(os.Stdout, "CA key %q created: %s\n", caKeyFile, ssh.FingerprintSHA256(publicKey))
+		return nil
+	},
+}
+
+// marshalED25519PrivateKey encodes the key in OpenSSH private key format
+// (`openssh-key-v1`), without passphrase.
+func marshalED25519PrivateKey(key ed25519.PrivateKey) (block *pem.Block, err error) {
+	var check [4]byte
+	if _, err = rand.Read(check[:]); err != nil {
+		return
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	priv := struct {
+		Check1, Check2 uint32
+		KeyType        string
+		Pub, Priv      []byte
+		Comment        string
+		Pad            []byte `ssh:"rest"`
+	}{
+		Check1:  binary.BigEndian.Uint32(check[:]),
+		Check2:  binary.BigEndian.Uint32(check[:]),
+		KeyType: ssh.KeyAlgoED25519,
+		Pub:     pub,
+		Priv:    key,
+	}
+	// the private section is padded to the cipher block size (8 for none)
+	for i := 1; (len(ssh.Marshal(priv)))%8 != 0; i++ {
+		priv.Pad = append(priv.Pad, byte(i))
+	}
+	pubKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return
+	}
+	data := ssh.Marshal(struct {
+		CipherName, KdfName, KdfOpts string
+		NumKeys                      uint32
+		PubKey, PrivKeyBlock         []byte
+	}{"none", "none", "", 1, pubKey.Marshal(), ssh.Marshal(priv)})
+	return &pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte("openssh-key-v1\x00"), data...),
+	}, nil
+}
+
+func init() {
+	caCmd.AddCommand(caInitCmd)
+	caInitCmd.Flags().StringP("type", "t", "ed25519", "Key type: `ed25519` or `rsa`")
+	caInitCmd.Flags().BoolP("force", "f", false, "Overwrite existing CA key")
+}
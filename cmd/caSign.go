@@ -0,0 +1,156 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/moisespsena-go/xssh/common"
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var caSignCmd = &cobra.Command{
+	Use:   "sign PUB_KEY_FILE...",
+	Short: "Sign one or more user or access point public keys",
+	Long: `Sign one or more user or access point public keys.
+
+The certificate of PUB_KEY_FILE is written to the same path with ` + q("-cert.pub") + `
+suffix (` + q("id_rsa.pub") + ` -> ` + q("id_rsa-cert.pub") + `).
+`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var (
+			opts = server.CertificateOptions{
+				CriticalOptions: map[string]string{},
+				Extensions:      map[string]string{},
+				SignedBy:        common.CurrentUser.Username,
+			}
+			validity      time.Duration
+			options, exts []string
+			noDefaultExts bool
+			sourceAddress string
+		)
+
+		if opts.Principals, err = cmd.Flags().GetStringSlice("principals"); err != nil {
+			return
+		}
+		if opts.KeyId, err = cmd.Flags().GetString("key-id"); err != nil {
+			return
+		}
+		if opts.IsAp, err = cmd.Flags().GetBool("ap"); err != nil {
+			return
+		}
+		if validity, err = cmd.Flags().GetDuration("validity"); err != nil {
+			return
+		}
+		if sourceAddress, err = cmd.Flags().GetString("source-address"); err != nil {
+			return
+		}
+		if options, err = cmd.Flags().GetStringSlice("option"); err != nil {
+			return
+		}
+		if exts, err = cmd.Flags().GetStringSlice("extension"); err != nil {
+			return
+		}
+		if noDefaultExts, err = cmd.Flags().GetBool("no-default-extensions"); err != nil {
+			return
+		}
+
+		if len(opts.Principals) == 0 {
+			return fmt.Errorf("`--principals` flag is required")
+		}
+		if validity <= 0 {
+			return fmt.Errorf("bad `--validity` flag value")
+		}
+
+		opts.ValidAfter = time.Now().Add(-time.Minute)
+		opts.ValidBefore = opts.ValidAfter.Add(validity + time.Minute)
+
+		if sourceAddress != "" {
+			opts.CriticalOptions[server.CertOptionSourceAddress] = sourceAddress
+		}
+		for _, opt := range options {
+			parts := strings.SplitN(opt, "=", 2)
+			opts.CriticalOptions[parts[0]] = strings.Join(parts[1:], "")
+		}
+		if !noDefaultExts {
+			for name, value := range server.DefaultCertificateExtensions {
+				opts.Extensions[name] = value
+			}
+		}
+		for _, ext := range exts {
+			parts := strings.SplitN(ext, "=", 2)
+			opts.Extensions[parts[0]] = strings.Join(parts[1:], "")
+		}
+
+		data, err := ioutil.ReadFile(caKeyFile)
+		if err != nil {
+			return fmt.Errorf("read CA key file failed: %v", err)
+		}
+		ca, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return fmt.Errorf("parse CA key file failed: %v", err)
+		}
+
+		return withCertificates(func(certs *server.Certificates) (err error) {
+			for i, pth := range args {
+				var (
+					data []byte
+					key  ssh.PublicKey
+					cert *ssh.Certificate
+					o    = opts
+				)
+				if data, err = ioutil.ReadFile(pth); err != nil {
+					return fmt.Errorf("read public key %d %q failed: %v", i, pth, err)
+				}
+				if key, _, _, _, err = ssh.ParseAuthorizedKey(data); err != nil {
+					return fmt.Errorf("parse public key %d %q failed: %v", i, pth, err)
+				}
+				if o.KeyId == "" {
+					o.KeyId = strings.Join(o.Principals, ",")
+				}
+				if cert, err = certs.Sign(ca, key, &o); err != nil {
+					return fmt.Errorf("sign public key %d %q failed: %v", i, pth, err)
+				}
+				certFile := strings.TrimSuffix(pth, ".pub") + "-cert.pub"
+				if err = ioutil.WriteFile(certFile, ssh.MarshalAuthorizedKey(cert), 0644); err != nil {
+					return fmt.Errorf("write certificate %q failed: %v", certFile, err)
+				}
+				fmt.Fprintf(os.Stdout, "Certificate #%d of %q written to %q, valid until %v\n", cert.Serial, pth,
+					certFile, o.ValidBefore.Format(time.RFC3339))
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	caCmd.AddCommand(caSignCmd)
+	flags := caSignCmd.Flags()
+	flags.StringSliceP("principals", "n", nil, "Principals (user names). For access points, use the `"+server.CertPrincipalApPrefix+"` prefix or the `--ap` flag")
+	flags.StringP("key-id", "I", "", "Certificate key ID (default is the principals)")
+	flags.BoolP("ap", "A", false, "Sign access point certificate")
+	flags.DurationP("validity", "V", 8*time.Hour, "Validity interval")
+	flags.String("source-address", "", "Comma separated list of allowed client addresses in CIDR or IP format")
+	flags.StringSliceP("option", "O", nil, "Critical option in `NAME[=VALUE]` format")
+	flags.StringSliceP("extension", "E", nil, "Extension in `NAME[=VALUE]` format")
+	flags.Bool("no-default-extensions", false, "Do not add the default extensions (permit-pty, permit-port-forwarding, ...)")
+}
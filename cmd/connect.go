@@ -41,16 +41,16 @@ import (
 
 func connect(done chan interface{}, tlsConfig *tls.Config, hostPort, token, ap, service string, rwc io.ReadWriteCloser) (closer io.Closer, err error) {
 	var (
-		server = "ws"
-		origin = "http"
+		serverURL = "ws"
+		origin    = "http"
 	)
 
 	if tlsConfig != nil {
-		server += "s"
+		serverURL += "s"
 		origin += "s"
 	}
 
-	server += "://" + hostPort
+	serverURL += "://" + hostPort + server.TunnelPath
 	origin += "://" + hostPort
 
 	var h = make(http.Header)
@@ -58,7 +58,7 @@ func connect(done chan interface{}, tlsConfig *tls.Config, hostPort, token, ap,
 	h.Set("X-Service", service)
 	h.Set("Authorization", "Token "+token)
 
-	cfg, _ := websocket.NewConfig(server, origin)
+	cfg, _ := websocket.NewConfig(serverURL, origin)
 	cfg.Header = h
 	cfg.Dialer = &net.Dialer{Timeout: 3 * time.Second}
 	if tlsConfig != nil {
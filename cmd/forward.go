@@ -38,13 +38,15 @@ DSN is [USER:]AP_NAME@XSSH_SERVER_HOST
 # SERVICE
 
 SERVICE is pair of name and local addr (NAME:ADDR).
-If ADDR is empty, uses localhost:0.
+If ADDR is empty, uses localhost:0. For UDP services, prefix ADDR
+with 'udp:' (the AP service must be UDP).
 
 Examples:
 - 'ssh' eq 'ssh::2222' eq 'ssh:localhost:2222' (only ssh service has default port)
 - 'ssh:domain.com:' eq 'ssh:domain.com:2222'
 - 'a::7000' eq 'a:localhost:7000'
 - 'a:domain.com:7000'
+- 'dns:udp::5353' eq 'dns:udp:localhost:5353'
 `,
 	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
@@ -80,6 +82,7 @@ Examples:
 			DSN:              dsn,
 			KeyFile:          keyFile,
 			Port:             port,
+			HostKeyCallback:  hostKeyConfig.Callback(),
 		}
 
 		if t, err := c.Create(); err != nil {
@@ -54,6 +54,7 @@ DSN is [USER:]AP_NAME@XSSH_SERVER_HOST
 			DSN:              args[0],
 			KeyFile:          keyFile,
 			Port:             port,
+			HostKeyCallback:  hostKeyConfig.Callback(),
 		}
 
 		if t, err := c.Create(); err != nil {
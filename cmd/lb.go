@@ -0,0 +1,88 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var lbCmd = &cobra.Command{
+	Use:   "lb",
+	Short: "Load balancers manager",
+	Long: "Load balancers manager. The running server applies the changes on every " + q("--lb-reload-interval") +
+		" of " + q("serve") + " command or immediately on SIGHUP signal.",
+}
+
+func withLoadBalancers(f func(lbs *server.LoadBalancers) error) error {
+	return withDB(func(DB *server.DB) error {
+		return f(server.NewLoadBalancers(DB))
+	})
+}
+
+func getLoadBalancer(lbs *server.LoadBalancers, ap, service string) (lb *server.LoadBalancer, err error) {
+	if lb, err = lbs.Get(ap, service); err != nil {
+		return
+	} else if lb == nil {
+		return nil, fmt.Errorf("Load balancer %s/%s not found", ap, service)
+	}
+	return
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func strPtr(v *string) string {
+	if v == nil {
+		return "-"
+	}
+	return *v
+}
+
+func balancerName(lb *server.LoadBalancer) string {
+	if lb.Balancer == "" {
+		return server.DefaultBalancer
+	}
+	return lb.Balancer
+}
+
+// printLoadBalancers prints the load balancers table. The health is the
+// healthy and total endpoints count by `AP/SERVICE`.
+func printLoadBalancers(health map[string][2]int, lbs ...*server.LoadBalancer) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tAP\tSERVICE\tMAX_COUNT\tENDPOINTS\tBALANCER\tPUBLIC_ADDR\tUNIX_SOCKET\tHTTP_HOST\tHTTP_PATH\tHTTP_AUTH")
+	for i, lb := range lbs {
+		h := health[lb.Ap+"/"+lb.Service]
+		fmt.Fprintln(w, strconv.Itoa(i+1)+"\t"+lb.Ap+"\t"+lb.Service+"\t"+strconv.Itoa(lb.MaxCount)+"\t"+
+			strconv.Itoa(h[0])+"/"+strconv.Itoa(h[1])+" healthy\t"+balancerName(lb)+"\t"+strPtr(lb.PublicAddr)+"\t"+
+			strconv.FormatBool(lb.UnixSocket)+"\t"+strPtr(lb.HttpHost)+"\t"+lb.HttpPath+"\t"+
+			strconv.FormatBool(lb.HttpAuthEnabled))
+	}
+	return w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(lbCmd)
+	lbCmd.PersistentFlags().StringVar(&dbName, "db", dbName, "SQLite 3 database file")
+}
@@ -0,0 +1,60 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var lbAddCmd = &cobra.Command{
+	Use:   "add AP SERVICE",
+	Short: "Add load balancer of access point service",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var (
+			ap, service = args[0], args[1]
+			maxCount    int
+			publicAddr  string
+		)
+		if maxCount, err = cmd.Flags().GetInt("max-count"); err != nil {
+			return
+		}
+		if publicAddr, err = cmd.Flags().GetString("public-addr"); err != nil {
+			return
+		}
+
+		return withLoadBalancers(func(lbs *server.LoadBalancers) (err error) {
+			if err = lbs.Add(ap, service, maxCount, publicAddr); err != nil {
+				return fmt.Errorf("Add load balancer %s/%s failed: %v", ap, service, err)
+			}
+			if err = setLoadBalancer(cmd, lbs, ap, service, true); err != nil {
+				return
+			}
+			fmt.Fprintf(os.Stdout, "Load balancer %s/%s added!\n", ap, service)
+			return nil
+		})
+	},
+}
+
+func init() {
+	lbCmd.AddCommand(lbAddCmd)
+	lbAddCmd.Flags().IntP("max-count", "C", 2, "Max count of endpoints")
+	lbAddCmd.Flags().StringP("public-addr", "a", "", "Public TCP addr")
+	addLoadBalancerSetFlags(lbAddCmd)
+}
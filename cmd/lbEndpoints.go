@@ -0,0 +1,68 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var lbEndpointsCmd = &cobra.Command{
+	Use:   "endpoints [AP SERVICE]",
+	Short: "Show endpoints and health state of load balancers saved by running server",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 && len(args) != 2 {
+			return fmt.Errorf("accepts 0 or 2 arg(s), received %d", len(args))
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var (
+			asJSON      bool
+			ap, service string
+		)
+		if asJSON, err = cmd.Flags().GetBool("json"); err != nil {
+			return
+		}
+		if len(args) == 2 {
+			ap, service = args[0], args[1]
+		}
+		return withLoadBalancers(func(lbs *server.LoadBalancers) error {
+			var items = []*server.EndPointStatus{}
+			if err := lbs.EndPoints(ap, service, func(i int, st *server.EndPointStatus) error {
+				items = append(items, st)
+				return nil
+			}); err != nil {
+				return err
+			}
+			if asJSON {
+				return printJSON(items)
+			}
+			for i, st := range items {
+				fmt.Fprintln(os.Stdout, i+1, "\t", st.Ap+"/"+st.Service, st)
+			}
+			fmt.Fprintln(os.Stdout, len(items), "endpoints found.")
+			return nil
+		})
+	},
+}
+
+func init() {
+	lbCmd.AddCommand(lbEndpointsCmd)
+	lbEndpointsCmd.Flags().Bool("json", false, "JSON output")
+}
@@ -0,0 +1,33 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var lbHeaderCmd = &cobra.Command{
+	Use:   "header",
+	Short: "Load balancer HTTP request and response headers manager",
+	Long: "Load balancer HTTP request and response headers manager. The rules are applied in order. The header " +
+		"values accept the variables " + q("{"+strings.Join(server.HeaderTemplateVars, "}, {")+"}") + ".",
+}
+
+func init() {
+	lbCmd.AddCommand(lbHeaderCmd)
+}
@@ -0,0 +1,57 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var lbHeaderAddCmd = &cobra.Command{
+	Use:   "add AP SERVICE ACTION NAME [VALUE]",
+	Short: "Add HTTP header rule of load balancer",
+	Long: "Add HTTP header rule of load balancer. The ACTION is " + q(server.HeaderActionAdd) + ", " +
+		q(server.HeaderActionSet) + " or " + q(server.HeaderActionRemove) + ".",
+	Example: `  xssh lb header add my_ap web set X-Client-IP '{client_ip}'
+  xssh lb header add my_ap web remove X-Powered-By --response`,
+	Args: cobra.RangeArgs(4, 5),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		rule := server.HeaderRule{Action: args[2], Name: args[3]}
+		if len(args) == 5 {
+			rule.Value = args[4]
+		}
+		if rule.Response, err = cmd.Flags().GetBool("response"); err != nil {
+			return
+		}
+		return withLoadBalancers(func(lbs *server.LoadBalancers) (err error) {
+			if _, err = getLoadBalancer(lbs, args[0], args[1]); err != nil {
+				return
+			}
+			if err = lbs.HttpHeaderAdd(args[0], args[1], rule); err != nil {
+				return fmt.Errorf("Add header rule failed: %v", err)
+			}
+			fmt.Fprintln(os.Stdout, "Header rule added!")
+			return nil
+		})
+	},
+}
+
+func init() {
+	lbHeaderCmd.AddCommand(lbHeaderAddCmd)
+	lbHeaderAddCmd.Flags().Bool("response", false, "Change the response headers instead of upstream request headers")
+}
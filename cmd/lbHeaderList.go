@@ -0,0 +1,55 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var lbHeaderListCmd = &cobra.Command{
+	Use:   "list AP SERVICE",
+	Short: "Show HTTP header rules of load balancer",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var asJSON bool
+		if asJSON, err = cmd.Flags().GetBool("json"); err != nil {
+			return
+		}
+		return withLoadBalancers(func(lbs *server.LoadBalancers) (err error) {
+			var lb *server.LoadBalancer
+			if lb, err = getLoadBalancer(lbs, args[0], args[1]); err != nil {
+				return
+			}
+			rules := append(server.HeaderRules{}, lb.HttpHeaders...)
+			if asJSON {
+				return printJSON(rules)
+			}
+			for i, rule := range rules {
+				fmt.Fprintln(os.Stdout, i+1, "\t", rule)
+			}
+			fmt.Fprintln(os.Stdout, len(rules), "header rules found.")
+			return nil
+		})
+	},
+}
+
+func init() {
+	lbHeaderCmd.AddCommand(lbHeaderListCmd)
+	lbHeaderListCmd.Flags().Bool("json", false, "JSON output")
+}
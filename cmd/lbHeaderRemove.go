@@ -0,0 +1,54 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var lbHeaderRemoveCmd = &cobra.Command{
+	Use:   "remove AP SERVICE INDEX...",
+	Short: "Remove one or more HTTP header rules of load balancer by index (see " + q("xssh lb header list") + ")",
+	Args:  cobra.MinimumNArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var index []int
+		for _, arg := range args[2:] {
+			i, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("bad index %q", arg)
+			}
+			index = append(index, i)
+		}
+		return withLoadBalancers(func(lbs *server.LoadBalancers) (err error) {
+			if _, err = getLoadBalancer(lbs, args[0], args[1]); err != nil {
+				return
+			}
+			if err = lbs.HttpHeaderRemove(args[0], args[1], index...); err != nil {
+				return fmt.Errorf("Remove header rules %s failed: %v", args[2:], err)
+			}
+			fmt.Fprintln(os.Stdout, "Header rules removed!")
+			return nil
+		})
+	},
+}
+
+func init() {
+	lbHeaderCmd.AddCommand(lbHeaderRemoveCmd)
+}
@@ -0,0 +1,81 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var lbHttpUserAddCmd = &cobra.Command{
+	Use:   "add AP SERVICE USER",
+	Short: "Add or change HTTP user of load balancer",
+	Long: "Add or change HTTP user of load balancer. If " + q("--password") +
+		" flag is not set, the password is read from terminal or STDIN.",
+	Args: cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var password string
+		if password, err = cmd.Flags().GetString("password"); err != nil {
+			return
+		}
+		if password == "" {
+			if password, err = readPassword("Password: "); err != nil {
+				return
+			}
+		}
+		if password == "" {
+			return fmt.Errorf("Password is blank")
+		}
+		return withLoadBalancers(func(lbs *server.LoadBalancers) (err error) {
+			if _, err = getLoadBalancer(lbs, args[0], args[1]); err != nil {
+				return
+			}
+			if err = lbs.HttpUserAdd(args[0], args[1], args[2], password); err != nil {
+				return fmt.Errorf("Add HTTP user %q failed: %v", args[2], err)
+			}
+			fmt.Fprintf(os.Stdout, "HTTP user %q added!\n", args[2])
+			return nil
+		})
+	},
+}
+
+func readPassword(prompt string) (password string, err error) {
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		var data []byte
+		data, err = terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("Read password failed: %v", err)
+		}
+		return string(data), nil
+	}
+	if password, err = bufio.NewReader(os.Stdin).ReadString('\n'); err != nil && password == "" {
+		return "", fmt.Errorf("Read password failed: %v", err)
+	}
+	return strings.TrimRight(password, "\r\n"), nil
+}
+
+func init() {
+	lbHttpUserCmd.AddCommand(lbHttpUserAddCmd)
+	lbHttpUserAddCmd.Flags().StringP("password", "p", "", "The user password")
+}
@@ -0,0 +1,57 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var lbHttpUserImportCmd = &cobra.Command{
+	Use:   "import AP SERVICE HTPASSWD_FILE",
+	Short: "Import HTTP users of load balancer from htpasswd file",
+	Long: "Import HTTP users of load balancer from htpasswd file. Use " + q("-") +
+		" to read from STDIN. Accepts the bcrypt, APR1-MD5 and SHA1 formats.",
+	Args: cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var f = os.Stdin
+		if args[2] != "-" {
+			if f, err = os.Open(args[2]); err != nil {
+				return
+			}
+			defer f.Close()
+		}
+		return withLoadBalancers(func(lbs *server.LoadBalancers) (err error) {
+			if _, err = getLoadBalancer(lbs, args[0], args[1]); err != nil {
+				return
+			}
+			if count, err := lbs.HttpUsersImport(args[0], args[1], f); err != nil {
+				return fmt.Errorf("Import HTTP users failed: %v", err)
+			} else if count == 0 {
+				fmt.Fprintln(os.Stdout, "No HTTP users imported!")
+			} else {
+				fmt.Fprintln(os.Stdout, count, "HTTP users imported!")
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	lbHttpUserCmd.AddCommand(lbHttpUserImportCmd)
+}
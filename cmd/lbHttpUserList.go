@@ -0,0 +1,64 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var lbHttpUserListCmd = &cobra.Command{
+	Use:   "list AP SERVICE",
+	Short: "Show HTTP users of load balancer",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var asJSON bool
+		if asJSON, err = cmd.Flags().GetBool("json"); err != nil {
+			return
+		}
+		return withLoadBalancers(func(lbs *server.LoadBalancers) (err error) {
+			var (
+				users   server.HttpUsers
+				enabled bool
+			)
+			if _, err = getLoadBalancer(lbs, args[0], args[1]); err != nil {
+				return
+			}
+			if users, enabled, err = lbs.GetUsers(args[0], args[1]); err != nil {
+				return
+			}
+			names := append([]string{}, users.Names()...)
+			if asJSON {
+				return printJSON(struct {
+					Enabled bool     `json:"http_auth_enabled"`
+					Users   []string `json:"http_users"`
+				}{enabled, names})
+			}
+			for i, name := range names {
+				fmt.Fprintln(os.Stdout, i+1, "\t", name)
+			}
+			fmt.Fprintln(os.Stdout, len(names), "HTTP users found. Authentication enabled:", enabled)
+			return nil
+		})
+	},
+}
+
+func init() {
+	lbHttpUserCmd.AddCommand(lbHttpUserListCmd)
+	lbHttpUserListCmd.Flags().Bool("json", false, "JSON output")
+}
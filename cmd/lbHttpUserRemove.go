@@ -0,0 +1,45 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var lbHttpUserRemoveCmd = &cobra.Command{
+	Use:   "remove AP SERVICE USER...",
+	Short: "Remove one or more HTTP users of load balancer",
+	Args:  cobra.MinimumNArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withLoadBalancers(func(lbs *server.LoadBalancers) (err error) {
+			if _, err = getLoadBalancer(lbs, args[0], args[1]); err != nil {
+				return
+			}
+			if err = lbs.HttpUserRemove(args[0], args[1], args[2:]...); err != nil {
+				return fmt.Errorf("Remove HTTP users %s failed: %v", args[2:], err)
+			}
+			fmt.Fprintln(os.Stdout, "HTTP users removed!")
+			return nil
+		})
+	},
+}
+
+func init() {
+	lbHttpUserCmd.AddCommand(lbHttpUserRemoveCmd)
+}
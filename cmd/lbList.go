@@ -0,0 +1,83 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+
+	"github.com/spf13/cobra"
+)
+
+var lbListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show load balancers",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var (
+			filter server.LoadBalancerFilter
+			asJSON bool
+		)
+		if filter.Ap, err = cmd.Flags().GetString("ap"); err != nil {
+			return
+		}
+		if asJSON, err = cmd.Flags().GetBool("json"); err != nil {
+			return
+		}
+		return withLoadBalancers(func(lbs *server.LoadBalancers) error {
+			var items = []*server.LoadBalancer{}
+			err := lbs.List(func(i int, lb *server.LoadBalancer) error {
+				items = append(items, lb)
+				return nil
+			}, &filter)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return printJSON(items)
+			}
+			if len(items) == 0 {
+				fmt.Fprintln(os.Stdout, "No load balancers found.")
+				return nil
+			}
+			var health = map[string][2]int{}
+			if err = lbs.EndPoints("", "", func(i int, st *server.EndPointStatus) error {
+				key := st.Ap + "/" + st.Service
+				h := health[key]
+				if st.Healthy && !st.Ejected() && !st.Draining {
+					h[0]++
+				}
+				h[1]++
+				health[key] = h
+				return nil
+			}); err != nil {
+				return err
+			}
+			if err = printLoadBalancers(health, items...); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stdout, "\n%d load balancers found.\n", len(items))
+			return nil
+		})
+	},
+}
+
+func init() {
+	lbCmd.AddCommand(lbListCmd)
+	lbListCmd.Flags().StringP("ap", "A", "", "Filter by access point name")
+	lbListCmd.Flags().Bool("json", false, "JSON output")
+}
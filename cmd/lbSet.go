@@ -0,0 +1,264 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var lbSetCmd = &cobra.Command{
+	Use:   "set AP SERVICE",
+	Short: "Change load balancer of access point service. Only the given flags are changed",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		return withLoadBalancers(func(lbs *server.LoadBalancers) (err error) {
+			if _, err = getLoadBalancer(lbs, args[0], args[1]); err != nil {
+				return
+			}
+			if err = setLoadBalancer(cmd, lbs, args[0], args[1], false); err != nil {
+				return
+			}
+			fmt.Fprintf(os.Stdout, "Load balancer %s/%s updated!\n", args[0], args[1])
+			return nil
+		})
+	},
+}
+
+func addLoadBalancerSetFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	if flags.Lookup("max-count") == nil {
+		flags.IntP("max-count", "C", 2, "Max count of endpoints")
+		flags.StringP("public-addr", "a", "", "Public TCP addr. Use empty value to disable")
+	}
+	flags.String("public-udp-addr", "", "Public UDP addr. The AP service must be UDP. Use empty value to disable")
+	flags.BoolP("unix-socket", "U", false, "Enable unix socket listener")
+	flags.StringSlice("allow", nil, "Source CIDRs or IP addresses accepted by public TCP addr and HTTP route. "+
+		"Use empty value to accept all sources")
+	flags.StringSlice("deny", nil, "Source CIDRs or IP addresses rejected by public TCP addr and HTTP route. "+
+		"Has precedence over "+q("--allow")+". Use empty value to disable")
+	flags.String("tls-sni-host", "", "TLS passthrough server name (SNI), routed without decrypt by "+
+		q("serve --tls-passthrough-addr")+" listener. Accepts wildcard hosts. Use empty value to disable")
+	flags.StringP("http-host", "H", "", "HTTP host. Accepts wildcard hosts ("+q("*.apps.example.com")+") and "+
+		q(server.DefaultHttpHost)+" for the requests not routed by other hosts. Use empty value to disable")
+	flags.StringP("http-path", "P", "", "HTTP path prefix or regular expression route prefixed by "+q("~")+
+		" (example: "+q("~^/api/v[0-9]+/")+")")
+	flags.Bool("http-auth", false, "Enable HTTP basic authentication")
+	flags.Bool("strip-prefix", false, "Remove the HTTP path prefix from upstream request path")
+	flags.String("path-rewrite", "", "Regular expression of upstream request path to replace by "+q("--path-rewrite-to")+
+		". Use empty value to disable")
+	flags.String("path-rewrite-to", "", "Replacement of "+q("--path-rewrite")+". Accepts "+q("$1")+" group references")
+	flags.String("upstream-host", "", "Upstream request Host header. Use empty value to keep the client host")
+	flags.StringP("balancer", "B", server.DefaultBalancer, "Endpoint balancer. Available: "+strings.Join(server.Balancers, ", "))
+	flags.Bool("sticky-sessions", false, "Enable HTTP sticky sessions by cookie")
+	flags.String("health-check", "", "Active health check of endpoints: "+q(server.HealthCheckTCP)+" (connect) or "+
+		q(server.HealthCheckHTTP)+" (GET request). Use empty value to disable")
+	flags.String("health-check-path", "/", "HTTP health check path")
+	flags.Int("health-check-interval", 10, "Health check interval in seconds")
+	flags.Int("health-check-timeout", 2, "Health check timeout in seconds")
+	flags.Int("healthy-threshold", 2, "Consecutive passed health checks to readmit an ejected endpoint")
+	flags.Int("unhealthy-threshold", 3, "Consecutive failed health checks to eject an endpoint")
+	flags.Int("max-retries", 0, "Max retries on another endpoint when the endpoint dial fails. Only the "+
+		"idempotent HTTP requests without body (GET, HEAD, OPTIONS and TRACE) are retried. Use 0 to disable")
+	flags.Int("outlier-errors", 0, "Consecutive traffic errors (connection failures and HTTP 5xx) to eject an "+
+		"endpoint temporarily. Use 0 to disable")
+	flags.Int("outlier-ejection-time", 30, "Outlier ejection time in seconds")
+	flags.Int("http-max-idle-conns", 8, "Max idle pooled HTTP connections per endpoint")
+	flags.Int("http-idle-timeout", 90, "Idle pooled HTTP connection lifetime in seconds")
+	flags.Int("http-timeout", 60, "Max time in seconds to wait the HTTP response headers. Use 0 to disable")
+}
+
+// setLoadBalancer sets the changed flags values. If created, the values used
+// by LoadBalancers.Add are skipped.
+func setLoadBalancer(cmd *cobra.Command, lbs *server.LoadBalancers, ap, service string, created bool) (err error) {
+	var (
+		flags  = cmd.Flags()
+		field  string
+		setErr = func(err error) error {
+			if err != nil {
+				return fmt.Errorf("Set %s of load balancer %s/%s failed: %v", field, ap, service, err)
+			}
+			return nil
+		}
+	)
+
+	if field = "max-count"; !created && flags.Changed(field) {
+		v, _ := flags.GetInt(field)
+		if err = setErr(lbs.SetMaxCount(ap, service, v)); err != nil {
+			return
+		}
+	}
+	if field = "public-addr"; !created && flags.Changed(field) {
+		v, _ := flags.GetString(field)
+		if err = setErr(lbs.SetPublicAddr(ap, service, v)); err != nil {
+			return
+		}
+	}
+	if field = "public-udp-addr"; flags.Changed(field) {
+		v, _ := flags.GetString(field)
+		if err = setErr(lbs.SetPublicUDPAddr(ap, service, v)); err != nil {
+			return
+		}
+	}
+	if field = "unix-socket"; flags.Changed(field) {
+		v, _ := flags.GetBool(field)
+		if err = setErr(lbs.SetUnixSocket(ap, service, v)); err != nil {
+			return
+		}
+	}
+	if field = "http-host"; flags.Changed(field) {
+		var value *string
+		if v, _ := flags.GetString(field); v != "" {
+			value = &v
+		}
+		if err = setErr(lbs.SetHttpHost(ap, service, value)); err != nil {
+			return
+		}
+	}
+	if field = "http-path"; flags.Changed(field) {
+		v, _ := flags.GetString(field)
+		if err = setErr(lbs.SetHttpPath(ap, service, v)); err != nil {
+			return
+		}
+	}
+	if field = "http-auth"; flags.Changed(field) {
+		v, _ := flags.GetBool(field)
+		if err = setErr(lbs.SetHttpAuthEnabled(ap, service, v)); err != nil {
+			return
+		}
+	}
+	if field = "strip-prefix"; flags.Changed(field) {
+		v, _ := flags.GetBool(field)
+		if err = setErr(lbs.SetStripPrefix(ap, service, v)); err != nil {
+			return
+		}
+	}
+	if field = "path-rewrite"; flags.Changed(field) || flags.Changed("path-rewrite-to") {
+		var lb *server.LoadBalancer
+		if lb, err = getLoadBalancer(lbs, ap, service); err != nil {
+			return
+		}
+		pattern, replacement := lb.PathRewrite, lb.PathRewriteReplacement
+		if flags.Changed(field) {
+			pattern, _ = flags.GetString(field)
+		}
+		if flags.Changed("path-rewrite-to") {
+			replacement, _ = flags.GetString("path-rewrite-to")
+		}
+		if err = setErr(lbs.SetPathRewrite(ap, service, pattern, replacement)); err != nil {
+			return
+		}
+	}
+	if field = "upstream-host"; flags.Changed(field) {
+		v, _ := flags.GetString(field)
+		if err = setErr(lbs.SetUpstreamHost(ap, service, v)); err != nil {
+			return
+		}
+	}
+	if field = "allow"; flags.Changed(field) {
+		v, _ := flags.GetStringSlice(field)
+		var nets server.IPNets
+		if nets, err = server.ParseIPNets(v...); err == nil {
+			err = lbs.SetAllow(ap, service, nets)
+		}
+		if err = setErr(err); err != nil {
+			return
+		}
+	}
+	if field = "deny"; flags.Changed(field) {
+		v, _ := flags.GetStringSlice(field)
+		var nets server.IPNets
+		if nets, err = server.ParseIPNets(v...); err == nil {
+			err = lbs.SetDeny(ap, service, nets)
+		}
+		if err = setErr(err); err != nil {
+			return
+		}
+	}
+	if field = "tls-sni-host"; flags.Changed(field) {
+		v, _ := flags.GetString(field)
+		if err = setErr(lbs.SetTLSSNIHost(ap, service, v)); err != nil {
+			return
+		}
+	}
+	if field = "balancer"; flags.Changed(field) {
+		v, _ := flags.GetString(field)
+		if err = setErr(lbs.SetBalancer(ap, service, v)); err != nil {
+			return
+		}
+	}
+	if field = "sticky-sessions"; flags.Changed(field) {
+		v, _ := flags.GetBool(field)
+		if err = setErr(lbs.SetStickySessions(ap, service, v)); err != nil {
+			return
+		}
+	}
+	if field = "health-check"; flags.Changed(field) {
+		v, _ := flags.GetString(field)
+		if err = setErr(lbs.SetHealthCheck(ap, service, v)); err != nil {
+			return
+		}
+	}
+	if field = "health-check-path"; flags.Changed(field) {
+		v, _ := flags.GetString(field)
+		if err = setErr(lbs.SetHealthCheckPath(ap, service, v)); err != nil {
+			return
+		}
+	}
+	for field, set := range map[string]func(ap, name string, value int) error{
+		"max-retries":    lbs.SetMaxRetries,
+		"outlier-errors": lbs.SetOutlierErrors,
+		"http-timeout":   lbs.SetHttpTimeout,
+	} {
+		if flags.Changed(field) {
+			v, _ := flags.GetInt(field)
+			if v < 0 {
+				return fmt.Errorf("Set %s of load balancer %s/%s failed: must be greater than or equal to 0", field, ap, service)
+			}
+			if err = set(ap, service, v); err != nil {
+				return fmt.Errorf("Set %s of load balancer %s/%s failed: %v", field, ap, service, err)
+			}
+		}
+	}
+	for field, set := range map[string]func(ap, name string, value int) error{
+		"outlier-ejection-time": lbs.SetOutlierEjectionTime,
+		"health-check-interval": lbs.SetHealthCheckInterval,
+		"health-check-timeout":  lbs.SetHealthCheckTimeout,
+		"healthy-threshold":     lbs.SetHealthyThreshold,
+		"unhealthy-threshold":   lbs.SetUnhealthyThreshold,
+		"http-max-idle-conns":   lbs.SetHttpMaxIdleConns,
+		"http-idle-timeout":     lbs.SetHttpIdleTimeout,
+	} {
+		if flags.Changed(field) {
+			v, _ := flags.GetInt(field)
+			if v < 1 {
+				return fmt.Errorf("Set %s of load balancer %s/%s failed: must be greater than 0", field, ap, service)
+			}
+			if err = set(ap, service, v); err != nil {
+				return fmt.Errorf("Set %s of load balancer %s/%s failed: %v", field, ap, service, err)
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	lbCmd.AddCommand(lbSetCmd)
+	addLoadBalancerSetFlags(lbSetCmd)
+}
@@ -0,0 +1,115 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/moisespsena-go/xssh/server"
+
+	"github.com/spf13/cobra"
+)
+
+var lbShowCmd = &cobra.Command{
+	Use:   "show AP SERVICE",
+	Short: "Show load balancer of access point service",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var asJSON bool
+		if asJSON, err = cmd.Flags().GetBool("json"); err != nil {
+			return
+		}
+		return withLoadBalancers(func(lbs *server.LoadBalancers) (err error) {
+			var (
+				lb    *server.LoadBalancer
+				users server.HttpUsers
+			)
+			if lb, err = getLoadBalancer(lbs, args[0], args[1]); err != nil {
+				return
+			}
+			if users, _, err = lbs.GetUsers(args[0], args[1]); err != nil {
+				return
+			}
+			var endPoints = []*server.EndPointStatus{}
+			if err = lbs.EndPoints(args[0], args[1], func(i int, st *server.EndPointStatus) error {
+				endPoints = append(endPoints, st)
+				return nil
+			}); err != nil {
+				return
+			}
+			if asJSON {
+				return printJSON(struct {
+					*server.LoadBalancer
+					HttpUsers []string                 `json:"http_users"`
+					EndPoints []*server.EndPointStatus `json:"endpoints"`
+				}{lb, append([]string{}, users.Names()...), endPoints})
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "AP:\t"+lb.Ap)
+			fmt.Fprintln(w, "SERVICE:\t"+lb.Service)
+			fmt.Fprintf(w, "MAX_COUNT:\t%d\n", lb.MaxCount)
+			fmt.Fprintln(w, "BALANCER:\t"+balancerName(lb))
+			fmt.Fprintln(w, "PUBLIC_ADDR:\t"+strPtr(lb.PublicAddr))
+			fmt.Fprintln(w, "PUBLIC_UDP_ADDR:\t"+lb.PublicUDPAddr)
+			fmt.Fprintf(w, "UNIX_SOCKET:\t%v\n", lb.UnixSocket)
+			fmt.Fprintln(w, "ALLOW:\t"+lb.Allow.String())
+			fmt.Fprintln(w, "DENY:\t"+lb.Deny.String())
+			fmt.Fprintln(w, "TLS_SNI_HOST:\t"+lb.TLSSNIHost)
+			fmt.Fprintln(w, "HTTP_HOST:\t"+strPtr(lb.HttpHost))
+			fmt.Fprintln(w, "HTTP_PATH:\t"+lb.HttpPath)
+			fmt.Fprintf(w, "HTTP_AUTH:\t%v\n", lb.HttpAuthEnabled)
+			fmt.Fprintf(w, "STRIP_PREFIX:\t%v\n", lb.StripPrefix)
+			if lb.PathRewrite == "" {
+				fmt.Fprintln(w, "PATH_REWRITE:\t-")
+			} else {
+				fmt.Fprintf(w, "PATH_REWRITE:\t%s => %s\n", lb.PathRewrite, lb.PathRewriteReplacement)
+			}
+			fmt.Fprintln(w, "UPSTREAM_HOST:\t"+lb.UpstreamHost)
+			fmt.Fprintf(w, "HTTP_POOL:\t%d idle conns per endpoint, idle timeout %ds, timeout %ds\n",
+				lb.HttpMaxIdleConns, lb.HttpIdleTimeout, lb.HttpTimeout)
+			fmt.Fprintf(w, "HTTP_HEADERS:\t%d\n", len(lb.HttpHeaders))
+			for i, rule := range lb.HttpHeaders {
+				fmt.Fprintf(w, "  %d\t%s\n", i+1, rule)
+			}
+			fmt.Fprintf(w, "STICKY_SESSIONS:\t%v\n", lb.StickySessions)
+			fmt.Fprintln(w, "HTTP_USERS:\t"+strings.Join(users.Names(), ", "))
+			if lb.HealthCheck == "" {
+				fmt.Fprintln(w, "HEALTH_CHECK:\t-")
+			} else {
+				fmt.Fprintf(w, "HEALTH_CHECK:\t%s %s every %ds, timeout %ds, healthy %d, unhealthy %d\n", lb.HealthCheck,
+					lb.HealthCheckPath, lb.HealthCheckInterval, lb.HealthCheckTimeout, lb.HealthyThreshold, lb.UnhealthyThreshold)
+			}
+			fmt.Fprintf(w, "RETRIES:\t%d\n", lb.MaxRetries)
+			if lb.OutlierErrors == 0 {
+				fmt.Fprintln(w, "OUTLIER_DETECTION:\t-")
+			} else {
+				fmt.Fprintf(w, "OUTLIER_DETECTION:\t%d errors, ejection %ds\n", lb.OutlierErrors, lb.OutlierEjectionTime)
+			}
+			fmt.Fprintf(w, "ENDPOINTS:\t%d\n", len(endPoints))
+			for i, st := range endPoints {
+				fmt.Fprintf(w, "  %d\t%s\n", i+1, st)
+			}
+			return w.Flush()
+		})
+	},
+}
+
+func init() {
+	lbCmd.AddCommand(lbShowCmd)
+	lbShowCmd.Flags().Bool("json", false, "JSON output")
+}
@@ -0,0 +1,44 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var portCmd = &cobra.Command{
+	Use:   "port",
+	Short: "Public TCP ports manager",
+	Long: `Public TCP ports manager
+
+Binds server TCP ports to AP services, including the services that are not
+load balanced. The connections are proxied to the service of AP and, for load
+balanced services, to its load balancer. Optionally, only the allowed source
+networks are accepted. The running server applies the changes on load
+balancers reload.
+`,
+}
+
+func withPublicPorts(f func(ports *server.PublicPorts) error) error {
+	return withDB(func(DB *server.DB) error {
+		return f(server.NewPublicPorts(DB))
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(portCmd)
+	portCmd.PersistentFlags().StringVar(&dbName, "db", dbName, "SQLite 3 database file")
+}
@@ -0,0 +1,63 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var portAddCmd = &cobra.Command{
+	Use:   "add AP SERVICE ADDR",
+	Short: "Bind the server address to the service of AP",
+	Long: `Bind the server address to the service of AP.
+
+ADDR is the ` + q("HOST:PORT") + ` address or the ` + q("HOST:FROM-TO") + ` port range. For port
+ranges, the first free port is allocated.
+
+Examples:
+  xssh port add ap1 db :5432
+  xssh port add ap1 web 0.0.0.0:9000-9100 --allow 10.0.0.0/8,192.168.1.20
+`,
+	Args: cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var (
+			values []string
+			allow  server.IPNets
+		)
+		if values, err = cmd.Flags().GetStringSlice("allow"); err != nil {
+			return
+		}
+		if allow, err = server.ParseIPNets(values...); err != nil {
+			return
+		}
+		return withPublicPorts(func(ports *server.PublicPorts) (err error) {
+			var addr string
+			if addr, err = ports.Add(args[0], args[1], args[2], allow); err != nil {
+				return fmt.Errorf("Add public port %s failed: %v", q(args[2]), err)
+			}
+			fmt.Fprintln(os.Stdout, "Public port", q(addr), "added!")
+			return nil
+		})
+	},
+}
+
+func init() {
+	portCmd.AddCommand(portAddCmd)
+	portAddCmd.Flags().StringSlice("allow", nil, "Allowed source CIDR or IP address. If empty, all sources are allowed")
+}
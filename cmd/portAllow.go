@@ -0,0 +1,50 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var portAllowCmd = &cobra.Command{
+	Use:   "allow ADDR [CIDR...]",
+	Short: "Set the source networks allowed to connect to public port. Without CIDR, all sources are allowed",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var allow server.IPNets
+		if allow, err = server.ParseIPNets(args[1:]...); err != nil {
+			return
+		}
+		return withPublicPorts(func(ports *server.PublicPorts) (err error) {
+			if err = ports.SetAllow(args[0], allow); err != nil {
+				return fmt.Errorf("Set allowed sources of %s failed: %v", q(args[0]), err)
+			}
+			if len(allow) == 0 {
+				fmt.Fprintln(os.Stdout, "Public port", q(args[0]), "allows all sources!")
+			} else {
+				fmt.Fprintln(os.Stdout, "Public port", q(args[0]), "allows", allow.String()+"!")
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	portCmd.AddCommand(portAllowCmd)
+}
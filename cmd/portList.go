@@ -0,0 +1,61 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var portListCmd = &cobra.Command{
+	Use:   "list [AP]",
+	Short: "List public ports",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var asJSON bool
+		if asJSON, err = cmd.Flags().GetBool("json"); err != nil {
+			return
+		}
+		var filter server.PublicPortsFilter
+		if len(args) == 1 {
+			filter.Ap = args[0]
+		}
+		return withPublicPorts(func(ports *server.PublicPorts) (err error) {
+			var list []*server.PublicPort
+			if err = ports.List(func(i int, p *server.PublicPort) error {
+				list = append(list, p)
+				return nil
+			}, &filter); err != nil {
+				return
+			}
+			if asJSON {
+				return printJSON(list)
+			}
+			for i, p := range list {
+				fmt.Fprintln(os.Stdout, i+1, "\t", p)
+			}
+			fmt.Fprintln(os.Stdout, len(list), "public ports found.")
+			return nil
+		})
+	},
+}
+
+func init() {
+	portCmd.AddCommand(portListCmd)
+	portListCmd.Flags().Bool("json", false, "JSON output")
+}
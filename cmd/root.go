@@ -28,6 +28,8 @@ import (
 var (
 	cfgFile string
 	keyFile = common.GetKeyFile()
+
+	hostKeyConfig = &common.HostKeyConfig{}
 )
 
 var rootCmd = &cobra.Command{
@@ -57,6 +59,9 @@ func init() {
 			os.Stderr.WriteString(banner)
 		}
 		rootCmd.PersistentFlags().StringVarP(&keyFile, "key-file", "i", keyFile, "ssh id file")
+		rootCmd.PersistentFlags().StringVar(&hostKeyConfig.KnownHostsFile, "known-hosts", common.DefaultKnownHostsFile(), "Known hosts file of XSSH servers")
+		rootCmd.PersistentFlags().BoolVar(&hostKeyConfig.Strict, "strict-host-key-checking", false, "Reject unknown XSSH servers instead of adding them to known hosts file")
+		rootCmd.PersistentFlags().StringSliceVar(&hostKeyConfig.Fingerprints, "host-key-fingerprint", nil, "Pin the XSSH server host key SHA256 fingerprint (ignores known hosts file)")
 	}
 }
 
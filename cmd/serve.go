@@ -15,9 +15,12 @@
 package cmd
 
 import (
+	"crypto/x509"
 	"fmt"
 	"github.com/robfig/cron"
+	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/moisespsena-go/httpu"
 	"github.com/moisespsena-go/overseer-task-restarts"
@@ -28,6 +31,7 @@ import (
 	"github.com/moisespsena-go/xssh/server"
 	"github.com/moisespsena-go/xssh/server/updater"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/acme"
 )
 
 var dbName = "xssh.db"
@@ -49,11 +53,31 @@ var serveCmd = &cobra.Command{
 		httpsCertFile, _ := cmd.Flags().GetString("https-cert-file")
 		httpsKeyFile, _ := cmd.Flags().GetString("https-key-file")
 		httpsDisableHttp2, _ := cmd.Flags().GetBool("https-disable-http2")
+		acmeEnabled, _ := cmd.Flags().GetBool("acme")
+		acmeEmail, _ := cmd.Flags().GetString("acme-email")
+		acmeDirectory, _ := cmd.Flags().GetString("acme-directory")
+		acmeCAFile, _ := cmd.Flags().GetString("acme-ca-file")
+		acmeCacheDir, _ := cmd.Flags().GetString("acme-cache-dir")
+		trustedUserCAKeys, _ := cmd.Flags().GetStringSlice("trusted-user-ca-keys")
+		revokedKeysFile, _ := cmd.Flags().GetString("revoked-keys-file")
+		apKnownHostsFile, _ := cmd.Flags().GetString("ap-known-hosts-file")
+		lbReloadInterval, _ := cmd.Flags().GetDuration("lb-reload-interval")
+		httpDomain, _ := cmd.Flags().GetString("http-domain")
+		tlsPassthroughAddr, _ := cmd.Flags().GetString("tls-passthrough-addr")
+		proxyProtocol, _ := cmd.Flags().GetBool("proxy-protocol")
+		common.UDPIdleTimeout, _ = cmd.Flags().GetDuration("udp-idle-timeout")
+		trustedProxiesValues, _ := cmd.Flags().GetStringSlice("trusted-proxies")
+		maxDrainTimeout, _ := cmd.Flags().GetDuration("max-drain-timeout")
 
 		if addr == "" {
 			addr = common.DefaultServerPublicAddr
 		}
 
+		trustedProxies, err := server.ParseIPNets(trustedProxiesValues...)
+		if err != nil {
+			return fmt.Errorf("bad trusted-proxies flag value: %v", err)
+		}
+
 		renewTokenSchedule, err := cron.Parse(renewToken)
 		if err != nil {
 			return fmt.Errorf("bad token-renew flag value: %v", err)
@@ -71,13 +95,51 @@ var serveCmd = &cobra.Command{
 			Updater = updater.NewNetUpdater(updaterAddr)
 		}
 
-		if https {
-			if _, err := os.Stat(httpsKeyFile); err != nil {
-				return fmt.Errorf("`--https-key-file` flag: %v", err)
+		var httpsConfig *server.HttpsConfig
+		if https && httpsAddr != "" {
+			httpsConfig = &server.HttpsConfig{
+				Addr:         httpsAddr,
+				DisableHttp2: httpsDisableHttp2,
+			}
+			// the default certificate is optional if exists ACME or host certificates
+			if _, err := os.Stat(httpsCertFile); err == nil || cmd.Flags().Changed("https-cert-file") ||
+				cmd.Flags().Changed("https-key-file") {
+				if _, err := os.Stat(httpsKeyFile); err != nil {
+					return fmt.Errorf("`--https-key-file` flag: %v", err)
+				}
+				if _, err := os.Stat(httpsCertFile); err != nil {
+					return fmt.Errorf("`--https-cert-file` flag: %v", err)
+				}
+				httpsConfig.CertFile, httpsConfig.KeyFile = httpsCertFile, httpsKeyFile
 			}
-			if _, err := os.Stat(httpsCertFile); err != nil {
-				return fmt.Errorf("`--https-cert-file` flag: %v", err)
+			if acmeEnabled {
+				httpsConfig.ACME = &server.ACMEConfig{
+					Email:        acmeEmail,
+					DirectoryURL: acmeDirectory,
+					CacheDir:     acmeCacheDir,
+				}
+				if acmeCAFile != "" {
+					pem, err := ioutil.ReadFile(acmeCAFile)
+					if err != nil {
+						return fmt.Errorf("`--acme-ca-file` flag: %v", err)
+					}
+					httpsConfig.ACME.RootCAs = x509.NewCertPool()
+					if !httpsConfig.ACME.RootCAs.AppendCertsFromPEM(pem) {
+						return fmt.Errorf("`--acme-ca-file` flag: no PEM certificates found")
+					}
+				}
 			}
+		} else if acmeEnabled {
+			return fmt.Errorf("`--acme` flag requires `--https` flag")
+		}
+
+		var certAuthority *server.CertAuthority
+		if len(trustedUserCAKeys) > 0 {
+			if certAuthority, err = server.NewCertAuthority(revokedKeysFile, trustedUserCAKeys...); err != nil {
+				return fmt.Errorf("`--trusted-user-ca-keys` flag: %v", err)
+			}
+		} else if revokedKeysFile != "" {
+			return fmt.Errorf("`--revoked-keys-file` flag requires `--trusted-user-ca-keys` flag")
 		}
 
 		var keepAliveConfig *httpu.KeepAliveConfig
@@ -103,29 +165,14 @@ var serveCmd = &cobra.Command{
 			done = DB.Close
 
 			var httpConfig *httpu.Config
-			if httpAddr != "" || (https && httpsAddr != "") {
+			if httpAddr != "" {
 				httpConfig = &httpu.Config{}
-				if httpAddr != "" {
-					httpConfig.Listeners = append(httpConfig.Listeners, httpu.ListenerConfig{
-						KeepAliveInterval:     keepAliveConfig,
-						KeepAliveIdleInterval: keepAliveIdleConfig,
-						KeepAliveCount:        httpKeepAliveCount,
-						Addr:                  httpu.Addr(httpAddr),
-					})
-				}
-				if https && httpsAddr != "" {
-					httpConfig.Listeners = append(httpConfig.Listeners, httpu.ListenerConfig{
-						KeepAliveInterval:     keepAliveConfig,
-						KeepAliveIdleInterval: keepAliveIdleConfig,
-						KeepAliveCount:        httpKeepAliveCount,
-						Addr:                  httpu.Addr(httpsAddr),
-						Tls: httpu.TlsConfig{
-							CertFile:    httpsCertFile,
-							KeyFile:     httpsKeyFile,
-							NPNDisabled: httpsDisableHttp2,
-						},
-					})
-				}
+				httpConfig.Listeners = append(httpConfig.Listeners, httpu.ListenerConfig{
+					KeepAliveInterval:     keepAliveConfig,
+					KeepAliveIdleInterval: keepAliveIdleConfig,
+					KeepAliveCount:        httpKeepAliveCount,
+					Addr:                  httpu.Addr(httpAddr),
+				})
 			}
 
 			return &server.Server{
@@ -135,9 +182,22 @@ var serveCmd = &cobra.Command{
 				Addr:               addr,
 				HttpConfig:         httpConfig,
 				Users:              server.NewUsers(DB),
+				CertAuthority:      certAuthority,
+				ServiceACL:         server.NewServiceACL(DB),
 				LoadBalancers:      server.NewLoadBalancers(DB),
+				TLSCertificates:    server.NewTLSCertificates(DB),
+				PublicPorts:        server.NewPublicPorts(DB),
+				Https:              httpsConfig,
 				NodeSockerPerm:     0666,
 				RenewTokenSchedule: renewTokenSchedule,
+
+				LoadBalancersReloadInterval: lbReloadInterval,
+				HttpDomain:                  httpDomain,
+				TLSPassthroughAddr:          tlsPassthroughAddr,
+				ProxyProtocol:               proxyProtocol,
+				ApKnownHostsFile:            apKnownHostsFile,
+				TrustedProxies:              trustedProxies,
+				MaxDrainTimeout:             maxDrainTimeout,
 			}
 		})).RunWait()
 	},
@@ -151,6 +211,22 @@ func init() {
 	flags.String("renew-token", "@daily", "Token renew interval. This is a cron Spec [see https://godoc.org/github.com/robfig/cron#hdr-CRON_Expression_Format].")
 	// net
 	flags.StringP("addr", "a", common.DefaultServerPublicAddr, "Public addr")
+	flags.String("ap-known-hosts-file", "ap_known_hosts", "Known hosts file of AP SSH servers exposed by service DSN. "+
+		"Unknown hosts are trusted on first use. The AP embedded SSH server is verified by the AP key")
+	// certificate authority
+	flags.StringSlice("trusted-user-ca-keys", nil, "Files of CA public keys trusted to sign user and AP certificates (authorized keys format)")
+	flags.String("revoked-keys-file", "", "OpenSSH key revocation list (KRL) file of CA signed certificates, generated by "+q("ssh-keygen -k"))
+	// load balancers
+	flags.Bool("proxy-protocol", false, "Accept the PROXY protocol v1 and v2 header on load balancers public "+
+		"addrs and TLS passthrough addr, when the server is behind a proxy (HAProxy or cloud load balancers)")
+	flags.StringSlice("trusted-proxies", nil, "Proxies CIDRs or IP addresses whose X-Forwarded-For header is "+
+		"used as HTTP client address by the load balancers source networks")
+	flags.Duration("udp-idle-timeout", common.UDPIdleTimeout, "Max time without datagrams of load balancers "+
+		"public UDP addr client flow")
+	flags.Duration("max-drain-timeout", server.DefaultMaxDrainTimeout, "Max drain timeout requested by AP on "+
+		"restart (see "+q("ap --drain-timeout")+" flag)")
+	flags.Duration("lb-reload-interval", 10*time.Second, "Interval to apply load balancers changes without restart. "+
+		"The SIGHUP signal reloads immediately. Use 0 to disable")
 	// updater
 	flags.String("updater-cmd", "", "Updater command")
 	flags.String("updater-addr", "", "Updater Addr")
@@ -159,12 +235,25 @@ func init() {
 	flags.String("http-keep-alive", "", httpKeepAliveUsage)
 	flags.String("http-keep-alive-idle", "", httpKeepAliveIdleUsage)
 	flags.Int("http-keep-alive-count", 0, "HTTP TCP Keep Alive count")
+	flags.String("http-domain", "", "Routes the "+q("<service>.<ap>.DOMAIN")+" HTTP hosts to the AP service "+
+		"(or its load balancer), if allowed by the service ACL rules of AP")
 	// https server
 	flags.Bool("https", false, "Enable HTTPS")
 	flags.String("https-addr", ":2443", "HTTPS Addr")
-	flags.String("https-cert-file", "server.crf", "TLS cert file")
-	flags.String("https-key-file", "server.key", "TLS key file")
+	flags.String("https-cert-file", "server.crf", "Default TLS cert file, used when none host certificate matches. "+
+		"Optional if ACME or host certificates are used")
+	flags.String("https-key-file", "server.key", "Default TLS key file")
 	flags.Bool("https-disable-http2", false, "Disable support for HTTP/2 protocol in HTTPS connections")
+	// acme
+	flags.Bool("acme", false, "Obtain the HTTPS certificates of load balancers HTTP hosts from ACME CA")
+	flags.String("acme-email", "", "ACME account contact email")
+	flags.String("acme-directory", acme.LetsEncryptURL, "ACME directory URL")
+	flags.String("acme-ca-file", "", "Trusted CA certificates file (PEM) of ACME directory server, like the local test servers")
+	flags.String("acme-cache-dir", "", "Directory to store the ACME account and certificates. Default stores it on DB")
+
+	// tls passthrough server
+	flags.String("tls-passthrough-addr", "", "TLS passthrough Addr: routes the TLS connections by server name (SNI) "+
+		"to the load balancers, without decrypt them. See "+q("lb set --tls-sni-host")+" flag")
 
 	serveCmd.PersistentFlags().StringVar(&dbName, "db", dbName, "SQLite 3 database file")
 }
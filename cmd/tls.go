@@ -0,0 +1,44 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var tlsCmd = &cobra.Command{
+	Use:   "tls",
+	Short: "HTTPS host certificates manager",
+	Long: `HTTPS host certificates manager
+
+The HTTPS server selects the certificate by the client server name (SNI):
+the host certificate, then the wildcard certificate of its parent domain
+(` + q("*.example.com") + `), then the ACME certificate (see ` + q("serve --acme") + `
+flag) and then the default certificate. The running server reloads the
+certificates on load balancers reload.
+`,
+}
+
+func withTLSCertificates(f func(certs *server.TLSCertificates) error) error {
+	return withDB(func(DB *server.DB) error {
+		return f(server.NewTLSCertificates(DB))
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(tlsCmd)
+	tlsCmd.PersistentFlags().StringVar(&dbName, "db", dbName, "SQLite 3 database file")
+}
@@ -0,0 +1,50 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var tlsAddCmd = &cobra.Command{
+	Use:   "add HOST CERT_FILE KEY_FILE",
+	Short: "Add or replace the certificate of host. HOST accepts wildcard (" + q("*.example.com") + ")",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var certPEM, keyPEM []byte
+		if certPEM, err = ioutil.ReadFile(args[1]); err != nil {
+			return
+		}
+		if keyPEM, err = ioutil.ReadFile(args[2]); err != nil {
+			return
+		}
+		return withTLSCertificates(func(certs *server.TLSCertificates) (err error) {
+			if err = certs.Add(args[0], certPEM, keyPEM); err != nil {
+				return fmt.Errorf("Add certificate of %s failed: %v", q(args[0]), err)
+			}
+			fmt.Fprintln(os.Stdout, "Certificate of", q(args[0]), "added!")
+			return nil
+		})
+	},
+}
+
+func init() {
+	tlsCmd.AddCommand(tlsAddCmd)
+}
@@ -0,0 +1,56 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var tlsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List host certificates",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var asJSON bool
+		if asJSON, err = cmd.Flags().GetBool("json"); err != nil {
+			return
+		}
+		return withTLSCertificates(func(certs *server.TLSCertificates) (err error) {
+			var list []*server.TLSCertificate
+			if err = certs.List(func(i int, c *server.TLSCertificate) error {
+				list = append(list, c)
+				return nil
+			}); err != nil {
+				return
+			}
+			if asJSON {
+				return printJSON(list)
+			}
+			for i, c := range list {
+				fmt.Fprintln(os.Stdout, i+1, "\t", c)
+			}
+			fmt.Fprintln(os.Stdout, len(list), "certificates found.")
+			return nil
+		})
+	},
+}
+
+func init() {
+	tlsCmd.AddCommand(tlsListCmd)
+	tlsListCmd.Flags().Bool("json", false, "JSON output")
+}
@@ -27,20 +27,17 @@ var usersAddCmd = &cobra.Command{
 	Short: "Add one or more users",
 	Args:  cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
-		var isAp, noUpdateKey bool
+		var isAp bool
 		if isAp, err = cmd.Flags().GetBool("ap"); err != nil {
 			return
 		}
-		if noUpdateKey, err = cmd.Flags().GetBool("no-update-key"); err != nil {
-			return
-		}
 
 		return withUsers(func(users *server.Users) (err error) {
 			for i, name := range args {
-				if err := users.Add(name, isAp, !noUpdateKey); err != nil {
+				if err := users.Add(name, isAp, false); err != nil {
 					return fmt.Errorf("Add user %d %q failed: %v", i, name, err)
 				} else {
-					fmt.Fprintf(os.Stdout, "User %q added!\n", name)
+					fmt.Fprintf(os.Stdout, "User %q added! Use `users enroll %s` to bind a key.\n", name, name)
 				}
 			}
 			return nil
@@ -52,4 +49,5 @@ func init() {
 	usersCmd.AddCommand(usersAddCmd)
 	usersAddCmd.Flags().BoolP("ap", "A", false, "User is access point")
 	usersAddCmd.Flags().BoolP("no-update-key", "K", false, "Disable auto update key")
+	usersAddCmd.Flags().MarkDeprecated("no-update-key", "keys are bound by `users enroll` tokens")
 }
@@ -0,0 +1,59 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/moisespsena-go/xssh/common"
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var usersEnrollCmd = &cobra.Command{
+	Use:   "enroll NAME",
+	Short: "Create one-time enrollment token to bind a new key to user",
+	Long: `Create one-time enrollment token to bind a new key to user.
+
+The user connects with the new key and presents the token with the
+` + q("enroll") + ` session command (AP: ` + q("xssh ap NAME --enroll-token TOKEN ...") + `).
+The token is consumed on use.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var ttl time.Duration
+		if ttl, err = cmd.Flags().GetDuration("ttl"); err != nil {
+			return
+		}
+		if ttl <= 0 {
+			return fmt.Errorf("bad `--ttl` flag value")
+		}
+		return withUsers(func(users *server.Users) (err error) {
+			var token string
+			if token, err = users.Enroll(args[0], ttl, common.CurrentUser.Username); err != nil {
+				return fmt.Errorf("Enroll user %q failed: %v", args[0], err)
+			}
+			fmt.Fprintln(os.Stdout, token)
+			return nil
+		})
+	},
+}
+
+func init() {
+	usersCmd.AddCommand(usersEnrollCmd)
+	usersEnrollCmd.Flags().Duration("ttl", time.Hour, "Token time to live")
+}
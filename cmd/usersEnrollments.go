@@ -0,0 +1,59 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+
+	"github.com/spf13/cobra"
+)
+
+var usersEnrollmentsCmd = &cobra.Command{
+	Use:   "enrollments [NAME]",
+	Short: "Show enrollment tokens audit",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		return withUsers(func(users *server.Users) error {
+			var (
+				count int
+				user  string
+			)
+			if len(args) == 1 {
+				user = args[0]
+			}
+			err := users.Enrollments(user, func(i int, e *server.Enrollment) error {
+				count = i
+				fmt.Fprintln(os.Stdout, i, "\t", e)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				fmt.Fprintln(os.Stdout, "No enrollments found.")
+			} else {
+				fmt.Fprintf(os.Stdout, "\n%d enrollments found.\n", count)
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	usersCmd.AddCommand(usersEnrollmentsCmd)
+}
@@ -0,0 +1,45 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var usersGrantCmd = &cobra.Command{
+	Use:   "grant USER AP...",
+	Short: "Grant user access to one or more access points",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withUsers(func(users *server.Users) (err error) {
+			if count, err := users.Grant(args[0], args[1:]...); err != nil {
+				return fmt.Errorf("Grant user %q access to %s failed: %v", args[0], args[1:], err)
+			} else if count == 0 {
+				fmt.Fprintln(os.Stdout, "No grants added!")
+			} else {
+				fmt.Fprintln(os.Stdout, count, "grants added!")
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	usersCmd.AddCommand(usersGrantCmd)
+}
@@ -0,0 +1,72 @@
+// Copyright © 2019 Moises P. Sena <moisespsena@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/moisespsena-go/xssh/server"
+	"github.com/spf13/cobra"
+)
+
+var usersKeysAddCmd = &cobra.Command{
+	Use:   "add USER NAME PUB_KEY_FILE",
+	Short: "Add named public key to user. If PUB_KEY_FILE is `-`, reads from STDIN",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var (
+			expires   string
+			expiresAt *time.Time
+			data      []byte
+		)
+		if expires, err = cmd.Flags().GetString("expires"); err != nil {
+			return
+		}
+		if expires != "" {
+			var t time.Time
+			if t, err = time.ParseInLocation("2006-01-02", expires, time.Local); err != nil {
+				if t, err = time.Parse(time.RFC3339, expires); err != nil {
+					return fmt.Errorf("bad `expires` flag value: %v", err)
+				}
+			}
+			expiresAt = &t
+		}
+
+		if args[2] == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(args[2])
+		}
+		if err != nil {
+			return fmt.Errorf("read public key failed: %v", err)
+		}
+
+		return withUsers(func(users *server.Users) (err error) {
+			if err = users.KeyAdd(args[0], args[1], string(data), expiresAt); err != nil {
+				return fmt.Errorf("Add key %q to user %q failed: %v", args[1], args[0], err)
+			}
+			fmt.Fprintf(os.Stdout, "Key %q added to user %q!\n", args[1], args[0])
+			return nil
+		})
+	},
+}
+
+func init() {
+	usersKeysCmd.AddCommand(usersKeysAddCmd)
+	usersKeysAddCmd.Flags().StringP("expires", "E", "", "Expiry date in `YYYY-MM-DD` or RFC3339 format")
+}
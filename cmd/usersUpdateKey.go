@@ -19,8 +19,9 @@ import (
 )
 
 var usersUpdateKeyCmd = &cobra.Command{
-	Use:   "auto-update-key",
-	Short: "Change users Auto Update Key Flag",
+	Use:        "auto-update-key",
+	Short:      "Change users Auto Update Key Flag",
+	Deprecated: "the flag is ignored, keys are bound by `users enroll` tokens",
 }
 
 func init() {
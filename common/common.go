@@ -1,12 +1,16 @@
 package common
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/user"
 	"path/filepath"
 	"sync"
 	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func NewDelayer(duration time.Duration) *Delayer {
@@ -85,3 +89,40 @@ func GetKeyFile(path ...string) string {
 	}
 	return p
 }
+
+// LoadSigner loads the private key file. If the certificate file
+// `KEY_FILE-cert.pub` exists, returns the certificate signer.
+func LoadSigner(keyFile string) (signer ssh.Signer, err error) {
+	var buf []byte
+	if buf, err = ioutil.ReadFile(keyFile); err != nil {
+		return nil, fmt.Errorf("load key failed: %v", err)
+	}
+	if signer, err = ssh.ParsePrivateKey(buf); err != nil {
+		return nil, fmt.Errorf("parse key failed: %v", err)
+	}
+
+	certFile := keyFile + "-cert.pub"
+	if buf, err = ioutil.ReadFile(certFile); err != nil {
+		if os.IsNotExist(err) {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("load certificate failed: %v", err)
+	}
+
+	var (
+		key  ssh.PublicKey
+		cert *ssh.Certificate
+		ok   bool
+	)
+	if key, _, _, _, err = ssh.ParseAuthorizedKey(buf); err != nil {
+		return nil, fmt.Errorf("parse certificate %q failed: %v", certFile, err)
+	}
+	if cert, ok = key.(*ssh.Certificate); !ok {
+		return nil, fmt.Errorf("%q is not a certificate", certFile)
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && time.Now().Unix() >= int64(cert.ValidBefore) {
+		log.Printf("certificate %q expired, using the key only", certFile)
+		return signer, nil
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
@@ -0,0 +1,73 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DialStatusRequestType is the global request type sent by AP to report the
+// backend dial result on load balancer entry point connections: after the
+// PROXY protocol header, the AP writes the dial status (see WriteDialStatus)
+// before any backend data. The server replies with the request type as
+// payload if it reads the dial status.
+const DialStatusRequestType = "dial-status@xssh"
+
+const (
+	dialStatusOK     = 0
+	dialStatusFailed = 1
+)
+
+// DialError is the backend dial failure reported by AP.
+type DialError struct {
+	Message string
+}
+
+func (err *DialError) Error() string {
+	return "AP backend dial failed: " + err.Message
+}
+
+// WriteDialStatus writes the dial status of dialErr to w. The failure status
+// is followed by the error message framed by its length (2 bytes, big
+// endian).
+func WriteDialStatus(w io.Writer, dialErr error) (err error) {
+	if dialErr == nil {
+		_, err = w.Write([]byte{dialStatusOK})
+		return
+	}
+	msg := dialErr.Error()
+	if len(msg) > 0xffff {
+		msg = msg[:0xffff]
+	}
+	frame := make([]byte, 3+len(msg))
+	frame[0] = dialStatusFailed
+	binary.BigEndian.PutUint16(frame[1:], uint16(len(msg)))
+	copy(frame[3:], msg)
+	_, err = w.Write(frame)
+	return
+}
+
+// ReadDialStatus reads the dial status written by WriteDialStatus. Returns
+// *DialError if AP failed to dial the backend.
+func ReadDialStatus(r io.Reader) (err error) {
+	var status [1]byte
+	if _, err = io.ReadFull(r, status[:]); err != nil {
+		return fmt.Errorf("read dial status failed: %v", err)
+	}
+	switch status[0] {
+	case dialStatusOK:
+		return nil
+	case dialStatusFailed:
+		var size [2]byte
+		if _, err = io.ReadFull(r, size[:]); err != nil {
+			return fmt.Errorf("read dial status failed: %v", err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err = io.ReadFull(r, msg); err != nil {
+			return fmt.Errorf("read dial status failed: %v", err)
+		}
+		return &DialError{string(msg)}
+	default:
+		return fmt.Errorf("bad dial status %d", status[0])
+	}
+}
@@ -0,0 +1,20 @@
+package common
+
+// DrainRequestType is the global request type sent by AP before disconnect:
+// the server stops routing new connections to the AP load balancer endpoints
+// and replies immediately with the active connections count. The AP repeats
+// the request until no connection is active or its timeout expires.
+const DrainRequestType = "drain"
+
+// DrainRequest is the payload of `drain` request.
+type DrainRequest struct {
+	// Timeout is the max wait time in seconds. The server clamps it to its
+	// max drain timeout.
+	Timeout uint32
+}
+
+// DrainReply is the reply payload of `drain` request.
+type DrainReply struct {
+	// Active is the connections count still active.
+	Active uint32
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyConfig is the server host key verification config.
+type HostKeyConfig struct {
+	// KnownHostsFile is the OpenSSH known_hosts format file. If blank, uses
+	// DefaultKnownHostsFile.
+	KnownHostsFile string
+	// Strict disables the trust on first use: unknown hosts are rejected
+	// instead of added to KnownHostsFile.
+	Strict bool
+	// Fingerprints pins the server host key. If not empty, KnownHostsFile is
+	// ignored and the key must have one of these SHA256 (or legacy MD5)
+	// fingerprints.
+	Fingerprints []string
+
+	mu sync.Mutex
+}
+
+// DefaultKnownHostsFile returns the `~/.xssh/known_hosts` path.
+func DefaultKnownHostsFile() string {
+	return filepath.Join(CurrentUser.HomeDir, ".xssh", "known_hosts")
+}
+
+// HostKeyMismatchError is returned when server host key does not match the
+// known key.
+type HostKeyMismatchError struct {
+	Host, KnownHostsFile string
+	Key                  ssh.PublicKey
+	Want                 []knownhosts.KnownKey
+}
+
+func (e HostKeyMismatchError) Error() string {
+	var known []string
+	for _, k := range e.Want {
+		known = append(known, fmt.Sprintf("%s (%s:%d)", ssh.FingerprintSHA256(k.Key), k.Filename, k.Line))
+	}
+	return fmt.Sprintf("WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED! host key of %q is %s, but expected %s",
+		e.Host, ssh.FingerprintSHA256(e.Key), strings.Join(known, " or "))
+}
+
+// Callback returns the ssh host key callback.
+func (c *HostKeyConfig) Callback() ssh.HostKeyCallback {
+	if len(c.Fingerprints) > 0 {
+		return c.pinnedCallback
+	}
+	return c.knownHostsCallback
+}
+
+func (c *HostKeyConfig) pinnedCallback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	sha256, md5 := ssh.FingerprintSHA256(key), ssh.FingerprintLegacyMD5(key)
+	for _, fp := range c.Fingerprints {
+		if fp == sha256 || strings.TrimPrefix(fp, "MD5:") == md5 {
+			return nil
+		}
+	}
+	return fmt.Errorf("host key of %q is %s, but expected %s", hostname, sha256, strings.Join(c.Fingerprints, " or "))
+}
+
+func (c *HostKeyConfig) knownHostsCallback(hostname string, remote net.Addr, key ssh.PublicKey) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pth := c.KnownHostsFile
+	if pth == "" {
+		pth = DefaultKnownHostsFile()
+	}
+
+	if _, err = os.Stat(pth); err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("stat of known hosts file failed: %v", err)
+		}
+		if c.Strict {
+			return fmt.Errorf("host %q is unknown: known hosts file %q does not exists", hostname, pth)
+		}
+	} else {
+		var cb ssh.HostKeyCallback
+		if cb, err = knownhosts.New(pth); err != nil {
+			return fmt.Errorf("load known hosts file failed: %v", err)
+		}
+		if err = cb(hostname, remote, key); err == nil {
+			return nil
+		}
+		keyErr, ok := err.(*knownhosts.KeyError)
+		if !ok {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return &HostKeyMismatchError{hostname, pth, key, keyErr.Want}
+		}
+		if c.Strict {
+			return fmt.Errorf("host %q is unknown (%s): add it to known hosts file %q", hostname, ssh.FingerprintSHA256(key), pth)
+		}
+	}
+
+	if err = os.MkdirAll(filepath.Dir(pth), 0700); err != nil {
+		return fmt.Errorf("create known hosts file directory failed: %v", err)
+	}
+
+	var f *os.File
+	if f, err = os.OpenFile(pth, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		return fmt.Errorf("open known hosts file failed: %v", err)
+	}
+	defer f.Close()
+
+	addrs := []string{knownhosts.Normalize(hostname)}
+	if remote != nil && remote.String() != hostname {
+		addrs = append(addrs, knownhosts.Normalize(remote.String()))
+	}
+
+	if _, err = f.WriteString(knownhosts.Line(addrs, key) + "\n"); err != nil {
+		return fmt.Errorf("write known hosts file failed: %v", err)
+	}
+	log.Printf("Permanently added %q (%s) to the list of known hosts %q", hostname, ssh.FingerprintSHA256(key), pth)
+	return nil
+}
@@ -0,0 +1,212 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PROXY protocol versions. See
+// https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+// proxyV2Signature is the PROXY protocol v2 header signature.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolOption is the service option of load balancer entry points to
+// receive the client address. The value is the PROXY protocol version sent by
+// AP to backend. Example: `*http?proxy_protocol=v1`.
+const ProxyProtocolOption = "proxy_protocol"
+
+// ValidateProxyProtocol returns error if version is not ProxyProtocolV1 or
+// ProxyProtocolV2.
+func ValidateProxyProtocol(version string) error {
+	switch version {
+	case ProxyProtocolV1, ProxyProtocolV2:
+		return nil
+	default:
+		return fmt.Errorf("bad PROXY protocol version %q. Available: %s, %s", version, ProxyProtocolV1, ProxyProtocolV2)
+	}
+}
+
+// ProxyHeader is the original client (Src) and server (Dst) addresses of
+// connection. The nil header is the LOCAL command (or UNKNOWN protocol): the
+// connection is not proxied for a client.
+type ProxyHeader struct {
+	Src, Dst *net.TCPAddr
+}
+
+// NewProxyHeader returns the header of src and dst addresses (`IP:PORT`). If
+// dst is blank or invalid, uses the unspecified address. Returns nil if src is
+// not an IP address, like the internal connections.
+func NewProxyHeader(src, dst string) *ProxyHeader {
+	srcAddr := parseIPAddr(src)
+	if srcAddr == nil {
+		return nil
+	}
+	h := &ProxyHeader{Src: srcAddr, Dst: parseIPAddr(dst)}
+	if h.Dst == nil || (h.Dst.IP.To4() == nil) != (h.Src.IP.To4() == nil) {
+		h.Dst = &net.TCPAddr{IP: net.IPv4zero}
+		if srcAddr.IP.To4() == nil {
+			h.Dst.IP = net.IPv6unspecified
+		}
+	}
+	return h
+}
+
+// parseIPAddr parses the `IP:PORT` address, without resolve host names.
+func parseIPAddr(addr string) *net.TCPAddr {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	a, err := parseProxyAddr(host, port)
+	if err != nil {
+		return nil
+	}
+	return a
+}
+
+func (h *ProxyHeader) String() string {
+	if h == nil {
+		return "LOCAL"
+	}
+	return h.Src.String() + "->" + h.Dst.String()
+}
+
+// Format returns the header encoded in version.
+func (h *ProxyHeader) Format(version string) []byte {
+	if version == ProxyProtocolV1 {
+		if h == nil {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		proto, src, dst := "TCP4", h.Src.IP.To4(), h.Dst.IP.To4()
+		if src == nil {
+			proto, src, dst = "TCP6", h.Src.IP.To16(), h.Dst.IP.To16()
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, src, dst, h.Src.Port, h.Dst.Port))
+	}
+
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	if h == nil {
+		// version 2, LOCAL command, UNSPEC family
+		buf.Write([]byte{0x20, 0x00, 0x00, 0x00})
+		return buf.Bytes()
+	}
+	fam, src, dst := byte(0x11), h.Src.IP.To4(), h.Dst.IP.To4()
+	if src == nil {
+		fam, src, dst = 0x21, h.Src.IP.To16(), h.Dst.IP.To16()
+	}
+	buf.Write([]byte{0x21, fam})
+	binary.Write(&buf, binary.BigEndian, uint16(len(src)*2+4))
+	buf.Write(src)
+	buf.Write(dst)
+	binary.Write(&buf, binary.BigEndian, uint16(h.Src.Port))
+	binary.Write(&buf, binary.BigEndian, uint16(h.Dst.Port))
+	return buf.Bytes()
+}
+
+// ReadProxyHeader reads the PROXY protocol v1 or v2 header.
+func ReadProxyHeader(r *bufio.Reader) (h *ProxyHeader, err error) {
+	var sig []byte
+	// the smallest header has 15 bytes
+	if sig, err = r.Peek(len(proxyV2Signature)); err != nil {
+		return
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+	return nil, errors.New("no PROXY protocol header")
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (h *ProxyHeader, err error) {
+	var line []byte
+	for len(line) <= 107 {
+		var b byte
+		if b, err = r.ReadByte(); err != nil {
+			return
+		}
+		if line = append(line, b); b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("bad PROXY protocol v1 header: line too long")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("bad PROXY protocol v1 header %q", strings.TrimSpace(string(line)))
+	}
+	h = &ProxyHeader{}
+	if h.Src, err = parseProxyAddr(fields[2], fields[4]); err != nil {
+		return nil, err
+	}
+	if h.Dst, err = parseProxyAddr(fields[3], fields[5]); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func parseProxyAddr(ip, port string) (*net.TCPAddr, error) {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	if addr.IP == nil {
+		return nil, fmt.Errorf("bad PROXY protocol address %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad PROXY protocol port %q", port)
+	}
+	addr.Port = int(p)
+	return addr, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (h *ProxyHeader, err error) {
+	var hdr [16]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("bad PROXY protocol v2 version %d", hdr[12]>>4)
+	}
+	data := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err = io.ReadFull(r, data); err != nil {
+		return
+	}
+	if hdr[12]&0x0f == 0x00 {
+		// LOCAL command
+		return nil, nil
+	}
+	var size int
+	switch hdr[13] {
+	case 0x11:
+		size = net.IPv4len
+	case 0x21:
+		size = net.IPv6len
+	default:
+		// UNSPEC and unix sockets
+		return nil, nil
+	}
+	if len(data) < size*2+4 {
+		return nil, errors.New("bad PROXY protocol v2 header: short addresses")
+	}
+	return &ProxyHeader{
+		Src: &net.TCPAddr{IP: net.IP(data[:size]), Port: int(binary.BigEndian.Uint16(data[size*2:]))},
+		Dst: &net.TCPAddr{IP: net.IP(data[size : size*2]), Port: int(binary.BigEndian.Uint16(data[size*2+2:]))},
+	}, nil
+}
@@ -1,3 +1,7 @@
 package common
 
 const SrvcSSH = "ssh"
+
+// EmbeddedSSHRequestType is the AP request sent when its ssh service is the
+// AP embedded SSH server, whose host key is the AP key.
+const EmbeddedSSHRequestType = "embedded-ssh@xssh"
@@ -0,0 +1,321 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDPPrefix is the address prefix of UDP services. Example: `udp:localhost:53`.
+const UDPPrefix = "udp:"
+
+// UDPIdleTimeout is the max time without datagrams of an UDP flow. After it,
+// the flow stream is closed.
+var UDPIdleTimeout = time.Minute
+
+// maxDatagramSize is the max UDP payload size.
+const maxDatagramSize = 65535
+
+// WriteDatagram writes the datagram p framed by its length (2 bytes, big
+// endian) to stream w.
+func WriteDatagram(w io.Writer, p []byte) (err error) {
+	if len(p) > maxDatagramSize {
+		return errors.New("datagram too large")
+	}
+	frame := make([]byte, 2+len(p))
+	binary.BigEndian.PutUint16(frame, uint16(len(p)))
+	copy(frame[2:], p)
+	_, err = w.Write(frame)
+	return
+}
+
+// ReadDatagram reads a datagram framed by WriteDatagram into buf. The buf must
+// have maxDatagramSize bytes.
+func ReadDatagram(r io.Reader, buf []byte) (n int, err error) {
+	var size [2]byte
+	if _, err = io.ReadFull(r, size[:]); err != nil {
+		return
+	}
+	n = int(binary.BigEndian.Uint16(size[:]))
+	if _, err = io.ReadFull(r, buf[:n]); err != nil {
+		return 0, err
+	}
+	return
+}
+
+// idleTimer calls the close func after the timeout without touch.
+type idleTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func newIdleTimer(timeout time.Duration, close func()) *idleTimer {
+	if timeout <= 0 {
+		timeout = UDPIdleTimeout
+	}
+	return &idleTimer{timeout, time.AfterFunc(timeout, close)}
+}
+
+func (t *idleTimer) touch() {
+	t.timer.Reset(t.timeout)
+}
+
+func (t *idleTimer) stop() {
+	t.timer.Stop()
+}
+
+// ServeUDPStream relays the datagrams framed on stream to the UDP service conn
+// and back, until stream is closed or the flow is idle for idleTimeout.
+func ServeUDPStream(prfx string, stream, conn net.Conn, idleTimeout time.Duration) (err error) {
+	var (
+		once sync.Once
+		done = make(chan struct{})
+		stop = func() {
+			once.Do(func() {
+				close(done)
+				conn.Close()
+				stream.Close()
+			})
+		}
+		idle = newIdleTimer(idleTimeout, stop)
+	)
+	defer func() {
+		idle.stop()
+		stop()
+	}()
+
+	go func() {
+		defer stop()
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				select {
+				case <-done:
+					return
+				default:
+					// ICMP port unreachable: the service may be restarting
+					continue
+				}
+			}
+			idle.touch()
+			if err = WriteDatagram(stream, buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := ReadDatagram(stream, buf)
+		if err != nil {
+			select {
+			case <-done:
+				// idle
+				return nil
+			default:
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		idle.touch()
+		if _, err = conn.Write(buf[:n]); err != nil {
+			log.Println(prfx, "write datagram failed:", err)
+		}
+	}
+}
+
+// udpFlowQueueSize is the max datagrams count queued per flow while the
+// stream is dialed or blocked. Datagrams beyond it are dropped.
+const udpFlowQueueSize = 64
+
+// UDPRelay relays the datagrams of UDP clients to streams dialed per client
+// (flow) and back. The flow stream is closed after IdleTimeout without
+// datagrams.
+type UDPRelay struct {
+	Name string
+	Conn net.PacketConn
+	// Accept reports whether a new flow of client is accepted. If nil,
+	// accepts all clients. The datagrams of denied clients are discarded.
+	Accept      func(client net.Addr) bool
+	Dial        func(client net.Addr) (net.Conn, error)
+	IdleTimeout time.Duration
+
+	flows map[string]*udpFlow
+	mu    sync.Mutex
+}
+
+// udpFlow is the flow of a client. The stream is dialed and written by the
+// flow goroutine, so a slow flow does not stall the other clients.
+type udpFlow struct {
+	queue  chan []byte
+	done   chan struct{}
+	once   sync.Once
+	idle   *idleTimer
+	stream net.Conn
+	mu     sync.Mutex
+}
+
+// setStream sets the dialed stream. Returns false if the flow is closed.
+func (f *udpFlow) setStream(stream net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-f.done:
+		return false
+	default:
+		f.stream = stream
+		return true
+	}
+}
+
+func (f *udpFlow) close() {
+	f.once.Do(func() {
+		f.mu.Lock()
+		close(f.done)
+		if f.stream != nil {
+			f.stream.Close()
+		}
+		f.mu.Unlock()
+	})
+}
+
+// Serve reads the client datagrams until the Conn is closed.
+func (r *UDPRelay) Serve() error {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, client, err := r.Conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			r.closeFlows()
+			return err
+		}
+		flow := r.flow(client)
+		if flow == nil {
+			continue
+		}
+		flow.idle.touch()
+		select {
+		case flow.queue <- append([]byte(nil), buf[:n]...):
+		default:
+			// the flow is dialing or its stream is blocked: drops it, as
+			// the network does.
+		}
+	}
+}
+
+// Close closes the Conn and the flows.
+func (r *UDPRelay) Close() error {
+	defer r.closeFlows()
+	return r.Conn.Close()
+}
+
+// Flows returns the active flows count.
+func (r *UDPRelay) Flows() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.flows)
+}
+
+func (r *UDPRelay) closeFlows() {
+	r.mu.Lock()
+	flows := r.flows
+	r.flows = nil
+	r.mu.Unlock()
+	for _, flow := range flows {
+		flow.idle.stop()
+		flow.close()
+	}
+}
+
+// flow returns the flow of client or starts a new flow. Returns nil if the
+// client is not accepted.
+func (r *UDPRelay) flow(client net.Addr) (flow *udpFlow) {
+	key := client.String()
+	r.mu.Lock()
+	flow = r.flows[key]
+	r.mu.Unlock()
+	if flow != nil {
+		return
+	}
+	if r.Accept != nil && !r.Accept(client) {
+		return nil
+	}
+
+	flow = &udpFlow{
+		queue: make(chan []byte, udpFlowQueueSize),
+		done:  make(chan struct{}),
+	}
+	flow.idle = newIdleTimer(r.IdleTimeout, flow.close)
+
+	r.mu.Lock()
+	if r.flows == nil {
+		r.flows = map[string]*udpFlow{}
+	}
+	r.flows[key] = flow
+	r.mu.Unlock()
+
+	go r.serveFlow(key, client, flow)
+	return
+}
+
+// serveFlow dials the flow stream and relays the datagrams until the flow is
+// closed.
+func (r *UDPRelay) serveFlow(key string, client net.Addr, flow *udpFlow) {
+	defer func() {
+		flow.idle.stop()
+		flow.close()
+		r.mu.Lock()
+		if r.flows[key] == flow {
+			delete(r.flows, key)
+		}
+		r.mu.Unlock()
+	}()
+
+	stream, err := r.Dial(client)
+	if err != nil {
+		log.Println(r.Name, "<"+key+">", "dial failed:", err)
+		return
+	}
+	if !flow.setStream(stream) {
+		stream.Close()
+		return
+	}
+	log.Println(r.Name, "<"+key+">", "flow opened")
+	defer log.Println(r.Name, "<"+key+">", "flow closed")
+
+	go func() {
+		defer flow.close()
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, err := ReadDatagram(stream, buf)
+			if err != nil {
+				return
+			}
+			flow.idle.touch()
+			if _, err = r.Conn.WriteTo(buf[:n], client); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-flow.done:
+			return
+		case p := <-flow.queue:
+			if err := WriteDatagram(stream, p); err != nil {
+				log.Println(r.Name, "<"+key+">", "write datagram failed:", err)
+				return
+			}
+		}
+	}
+}
@@ -2,7 +2,6 @@ package forwarder
 
 import (
 	"io"
-	"io/ioutil"
 	"log"
 	"sync"
 	"time"
@@ -19,7 +18,10 @@ type Forwarder struct {
 	UserName   string
 	ApName     string
 	KeyFile    string
-	services   []*Service
+	// HostKeyCallback verifies the server host key. If nil, uses the
+	// default common.HostKeyConfig callback.
+	HostKeyCallback gossh.HostKeyCallback
+	services        []*Service
 
 	client  *gossh.Client
 	delayer *common.Delayer
@@ -117,20 +119,18 @@ func (fw *Forwarder) SetReconnectTimeout(t time.Duration) {
 }
 
 func (fw Forwarder) connectToHost() (*gossh.Client, error) {
-	buf, err := ioutil.ReadFile(common.GetKeyFile(fw.KeyFile))
+	key, err := common.LoadSigner(common.GetKeyFile(fw.KeyFile))
 	if err != nil {
-		log.Fatalf("Load Key filed %v", err)
-	}
-	key, err := gossh.ParsePrivateKey(buf)
-	if err != nil {
-		log.Fatalf("Parse Key filed %v", err)
+		log.Fatal(err)
 	}
 
 	sshConfig := &gossh.ClientConfig{
 		User: fw.UserName + ":" + fw.ApName,
 		Auth: []gossh.AuthMethod{gossh.PublicKeys(key)},
 	}
-	sshConfig.HostKeyCallback = gossh.InsecureIgnoreHostKey()
+	if sshConfig.HostKeyCallback = fw.HostKeyCallback; sshConfig.HostKeyCallback == nil {
+		sshConfig.HostKeyCallback = (&common.HostKeyConfig{}).Callback()
+	}
 
 	client, err := gossh.Dial("tcp", fw.ServerAddr, sshConfig)
 	if err != nil {
@@ -190,4 +190,4 @@ func (fw *Forwarder) Start(done func()) (stop task.Stoper, err error) {
 	fw.setup()
 	go fw.forever(done)
 	return fw, nil
-}
\ No newline at end of file
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/moisespsena-go/xssh/common"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 type Creator struct {
@@ -15,6 +16,7 @@ type Creator struct {
 	DSN              string
 	KeyFile          string
 	Port             int
+	HostKeyCallback  gossh.HostKeyCallback
 }
 
 func (c Creator) Create() (client *Forwarder, err error) {
@@ -67,6 +69,7 @@ func (c Creator) Create() (client *Forwarder, err error) {
 	client.ApName = apName
 	client.ServerAddr = fmt.Sprintf("%v:%d", serverAddr, c.Port)
 	client.KeyFile = c.KeyFile
+	client.HostKeyCallback = c.HostKeyCallback
 
 	for i, name := range c.ServiceNames {
 		parts := strings.SplitN(name, ":", 2)
@@ -1,6 +1,7 @@
 package forwarder
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -22,9 +23,13 @@ func (e IsServiceRunningError) Error() string {
 }
 
 type Service struct {
-	Name    string
-	Addr    string
+	Name string
+	Addr string
+	// UDP is true if Addr is a local UDP addr. The datagrams of each client are
+	// relayed to AP service as a flow.
+	UDP     bool
 	ln      net.Listener
+	relay   *common.UDPRelay
 	mu      sync.Mutex
 	fw      *Forwarder
 	stop    bool
@@ -40,6 +45,10 @@ func (s *Service) PostTaskStart(r *task.Runner) {
 }
 
 func NewService(name string, addr string) (*Service, error) {
+	var udp bool
+	if strings.HasPrefix(addr, common.UDPPrefix) {
+		udp, addr = true, strings.TrimPrefix(addr, common.UDPPrefix)
+	}
 	if addr == "" {
 		addr = ":"
 	}
@@ -51,7 +60,14 @@ func NewService(name string, addr string) (*Service, error) {
 		parts[1] = "0"
 	}
 
-	if parts[1] == "0" {
+	if parts[1] == "0" && udp {
+		l, err := net.ListenPacket("udp", "localhost:0")
+		if err != nil {
+			return nil, err
+		}
+		defer l.Close()
+		parts[1] = fmt.Sprint(l.LocalAddr().(*net.UDPAddr).Port)
+	} else if parts[1] == "0" {
 		addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
 		if err != nil {
 			return nil, err
@@ -65,13 +81,16 @@ func NewService(name string, addr string) (*Service, error) {
 		parts[1] = fmt.Sprint(l.Addr().(*net.TCPAddr).Port)
 	}
 
-	return &Service{Name: name, Addr: strings.Join(parts, ":")}, nil
+	return &Service{Name: name, Addr: strings.Join(parts, ":"), UDP: udp}, nil
 }
 
 func (s *Service) Stop() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.stop = true
+	if s.relay != nil {
+		s.relay.Close()
+	}
 }
 
 func (s *Service) IsRunning() bool {
@@ -115,8 +134,11 @@ func (s *Service) forever() error {
 		defer s.mu.Unlock()
 
 		s.running = false
-		s.ln.Close()
-		s.ln = nil
+		if s.ln != nil {
+			s.ln.Close()
+			s.ln = nil
+		}
+		s.relay = nil
 		if s.con != nil {
 			s.con.Close()
 			s.con = nil
@@ -128,6 +150,15 @@ func (s *Service) forever() error {
 		}
 	}()
 
+	if s.relay != nil {
+		err := s.relay.Serve()
+		if !s.stop {
+			log.Println("["+s.Name+"] read local datagrams failed:", err)
+			return err
+		}
+		return nil
+	}
+
 	for !s.stop {
 		conn, err := s.ln.Accept()
 		if err != nil {
@@ -141,6 +172,9 @@ func (s *Service) forever() error {
 }
 
 func (s *Service) Listen() (err error) {
+	if s.UDP {
+		return s.listenUDP()
+	}
 	s.ln, err = net.Listen("tcp", s.Addr)
 	if err != nil {
 		log.Fatalln("["+s.Name+"] listen to "+s.Addr+" failed:", err)
@@ -152,6 +186,39 @@ func (s *Service) Listen() (err error) {
 	return
 }
 
+func (s *Service) listenUDP() (err error) {
+	var conn net.PacketConn
+	if conn, err = net.ListenPacket("udp", s.Addr); err != nil {
+		log.Fatalln("["+s.Name+"] listen to "+common.UDPPrefix+s.Addr+" failed:", err)
+		return
+	}
+	s.Addr = conn.LocalAddr().String()
+	s.relay = &common.UDPRelay{
+		Name: "[" + s.Name + "]",
+		Conn: conn,
+		Dial: func(client net.Addr) (net.Conn, error) {
+			if s.fw.client == nil {
+				return nil, io.EOF
+			}
+			return s.dial()
+		},
+	}
+
+	log.Println("["+s.Name+"] listening on", common.UDPPrefix+s.Addr)
+	return
+}
+
+// dial opens a connection to the AP service.
+func (s *Service) dial() (conn net.Conn, err error) {
+	if conn, err = s.fw.client.Dial("unix", s.Name); err != nil {
+		if ok, reason, err2 := s.fw.client.SendRequest("service-access", true, []byte(s.Name)); err2 == nil && !ok && len(reason) > 0 {
+			err = errors.New(string(reason))
+		}
+		log.Println("["+s.Name+"] Connect to remote proxy server failed:", err)
+	}
+	return
+}
+
 func (s *Service) getConn() (conn net.Conn, err error) {
 	if s.con != nil {
 		return s.con, nil
@@ -165,9 +232,7 @@ func (s *Service) getConn() (conn net.Conn, err error) {
 		return nil, io.EOF
 	}
 
-	s.con, err = s.fw.client.Dial("unix", s.Name)
-	if err != nil {
-		log.Println("["+s.Name+"] Connect to remote proxy server failed:", err)
+	if s.con, err = s.dial(); err != nil {
 		return
 	}
 	return s.con, nil
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ServiceAccessDeniedError is returned when user is not allowed to dial
+// the service of AP.
+type ServiceAccessDeniedError struct {
+	User, Ap, Service string
+}
+
+func (e ServiceAccessDeniedError) Error() string {
+	return fmt.Sprintf("user %q is not allowed to dial service %q of AP %q", e.User, e.Service, e.Ap)
+}
+
+type ServiceACLRule struct {
+	User, Ap, Service string
+}
+
+func (r ServiceACLRule) String() string {
+	return r.User + " -> " + r.Ap + "/" + r.Service
+}
+
+type ServiceACLFilter struct {
+	User string
+	Ap   string
+}
+
+// ServiceACL restricts the service names that users can dial on access
+// points. Service is a pattern in `path.Match` format. If user does not have
+// rules for the AP, all services are allowed.
+type ServiceACL struct {
+	DB *DB
+}
+
+func NewServiceACL(db *DB) *ServiceACL {
+	return &ServiceACL{DB: db}
+}
+
+func (s *ServiceACL) Add(user, ap string, service ...string) (added int64, err error) {
+	for _, service := range service {
+		if _, err = path.Match(service, ""); err != nil {
+			return 0, fmt.Errorf("bad service pattern %q: %v", service, err)
+		}
+	}
+
+	for _, service := range service {
+		if result, err := s.DB.Exec("INSERT OR IGNORE INTO service_acl (user, ap, service) VALUES (?, ?, ?)", user, ap, service); err != nil {
+			return added, fmt.Errorf("DB Exec failed: %v", err)
+		} else if af, err := result.RowsAffected(); err != nil {
+			return added, fmt.Errorf("DB Get Affcted Rows failed: %v", err)
+		} else {
+			added += af
+		}
+	}
+	return
+}
+
+func (s *ServiceACL) Remove(user, ap string, service ...string) (removed int64, err error) {
+	sqls := "DELETE FROM service_acl WHERE user = ? AND ap = ?"
+	var args = []interface{}{user, ap}
+
+	if len(service) > 0 {
+		sqls += " AND service IN (?" + strings.Repeat(",?", len(service)-1) + ")"
+		for _, service := range service {
+			args = append(args, service)
+		}
+	}
+
+	if result, err := s.DB.Exec(sqls, args...); err != nil {
+		return 0, fmt.Errorf("DB Exec failed: %v", err)
+	} else if removed, err = result.RowsAffected(); err != nil {
+		err = fmt.Errorf("DB Get Affcted Rows failed: %v", err)
+		return 0, err
+	}
+	return
+}
+
+func (s *ServiceACL) List(cb func(i int, r *ServiceACLRule) error, filter *ServiceACLFilter) (err error) {
+	var (
+		where = []string{"1"}
+		args  = []interface{}{}
+	)
+
+	if filter != nil {
+		if filter.User != "" {
+			where = append(where, "user = ?")
+			args = append(args, filter.User)
+		}
+		if filter.Ap != "" {
+			where = append(where, "ap = ?")
+			args = append(args, filter.Ap)
+		}
+	}
+
+	rows, err := s.DB.Query("SELECT user, ap, service FROM service_acl WHERE "+
+		strings.Join(where, " AND ")+" ORDER BY user, ap, service ASC", args...)
+	if err != nil {
+		return fmt.Errorf("DB Query failed: %v", err)
+	}
+
+	defer rows.Close()
+
+	for i := 1; rows.Next(); i++ {
+		var r ServiceACLRule
+		if err = rows.Scan(&r.User, &r.Ap, &r.Service); err != nil {
+			return fmt.Errorf("Scan ACL rule %d failed: %v", i, err)
+		}
+		if err = cb(i, &r); err != nil {
+			if err == ErrStopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Check returns ServiceAccessDeniedError if user is not allowed to dial
+// service of AP.
+func (s *ServiceACL) Check(user, ap, service string) (err error) {
+	var (
+		rules   int
+		allowed bool
+	)
+
+	err = s.List(func(i int, r *ServiceACLRule) error {
+		rules = i
+		if ok, _ := path.Match(r.Service, service); ok {
+			allowed = true
+			return ErrStopIteration
+		}
+		return nil
+	}, &ServiceACLFilter{User: user, Ap: ap})
+
+	if err != nil {
+		return
+	}
+
+	if rules > 0 && !allowed {
+		return &ServiceAccessDeniedError{user, ap, service}
+	}
+	return nil
+}
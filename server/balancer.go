@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	BalancerLeastConnections = "least-connections"
+	BalancerRoundRobin       = "round-robin"
+	BalancerWeighted         = "weighted"
+	BalancerRandomTwoChoices = "random-two-choices"
+	BalancerIPHash           = "ip-hash"
+
+	DefaultBalancer = BalancerLeastConnections
+)
+
+// Balancers is the available balancer names.
+var Balancers = []string{
+	BalancerLeastConnections,
+	BalancerRoundRobin,
+	BalancerWeighted,
+	BalancerRandomTwoChoices,
+	BalancerIPHash,
+}
+
+// Balancer selects the endpoint for a new connection of client. The endPoints
+// is not empty and sorted by key.
+type Balancer interface {
+	Next(endPoints []*NodeServiceListener, clientAddr string) *NodeServiceListener
+}
+
+// NewBalancer creates the balancer by name. If name is blank, uses
+// DefaultBalancer.
+func NewBalancer(name string) (Balancer, error) {
+	switch name {
+	case "", BalancerLeastConnections:
+		return &LeastConnectionsBalancer{}, nil
+	case BalancerRoundRobin:
+		return &RoundRobinBalancer{}, nil
+	case BalancerWeighted:
+		return &WeightedBalancer{}, nil
+	case BalancerRandomTwoChoices:
+		return &RandomTwoChoicesBalancer{}, nil
+	case BalancerIPHash:
+		return &IPHashBalancer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown balancer %q. Available: %s", name, strings.Join(Balancers, ", "))
+	}
+}
+
+// LeastConnectionsBalancer selects the endpoint with fewest active
+// connections per weight.
+type LeastConnectionsBalancer struct{}
+
+func (LeastConnectionsBalancer) Next(endPoints []*NodeServiceListener, clientAddr string) (sl *NodeServiceListener) {
+	var min float64
+	for _, sl2 := range endPoints {
+		if load := sl2.load(); sl == nil || load < min {
+			sl, min = sl2, load
+		}
+	}
+	return
+}
+
+// RoundRobinBalancer selects the endpoints in turn.
+type RoundRobinBalancer struct {
+	next int
+	mu   sync.Mutex
+}
+
+func (b *RoundRobinBalancer) Next(endPoints []*NodeServiceListener, clientAddr string) *NodeServiceListener {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := b.next % len(endPoints)
+	b.next = i + 1
+	return endPoints[i]
+}
+
+// WeightedBalancer is the smooth weighted round-robin balancer. The endpoint
+// weight is defined by AP with the `weight` service option.
+type WeightedBalancer struct {
+	current map[string]int
+	mu      sync.Mutex
+}
+
+func (b *WeightedBalancer) Next(endPoints []*NodeServiceListener, clientAddr string) (sl *NodeServiceListener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var (
+		current = make(map[string]int, len(endPoints))
+		total   int
+	)
+	for _, sl2 := range endPoints {
+		weight := sl2.weight()
+		total += weight
+		current[sl2.key] = b.current[sl2.key] + weight
+		if sl == nil || current[sl2.key] > current[sl.key] {
+			sl = sl2
+		}
+	}
+	current[sl.key] -= total
+	b.current = current
+	return
+}
+
+// RandomTwoChoicesBalancer selects two random endpoints and uses the less
+// loaded of them.
+type RandomTwoChoicesBalancer struct{}
+
+func (RandomTwoChoicesBalancer) Next(endPoints []*NodeServiceListener, clientAddr string) *NodeServiceListener {
+	if len(endPoints) == 1 {
+		return endPoints[0]
+	}
+	i := rand.Intn(len(endPoints))
+	j := rand.Intn(len(endPoints) - 1)
+	if j >= i {
+		j++
+	}
+	if endPoints[j].load() < endPoints[i].load() {
+		return endPoints[j]
+	}
+	return endPoints[i]
+}
+
+// IPHashBalancer selects the endpoint by consistent hash (weighted rendezvous
+// hashing) of client IP. Only the clients of removed endpoint are remapped
+// when the endpoints change.
+type IPHashBalancer struct{}
+
+func (IPHashBalancer) Next(endPoints []*NodeServiceListener, clientAddr string) (sl *NodeServiceListener) {
+	var (
+		ip  = clientIP(clientAddr)
+		max float64
+	)
+	for _, sl2 := range endPoints {
+		h := fnv.New64a()
+		h.Write([]byte(ip))
+		h.Write([]byte{0})
+		h.Write([]byte(sl2.key))
+		// maps the hash to (0, 1) interval
+		score := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		score = -float64(sl2.weight()) / math.Log(score)
+		if sl == nil || score > max {
+			sl, max = sl2, score
+		}
+	}
+	return
+}
+
+// clientIP returns the IP of client addr. If addr is not a host:port pair,
+// returns it.
+func clientIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// parseServiceName splits the AP service name from the options query. Example:
+// `web?weight=3`.
+func parseServiceName(name string) (service string, options url.Values, err error) {
+	service = name
+	if pos := strings.IndexByte(name, '?'); pos != -1 {
+		service = name[:pos]
+		if options, err = url.ParseQuery(name[pos+1:]); err != nil {
+			err = fmt.Errorf("bad options of service %q: %v", service, err)
+			return
+		}
+	}
+	return
+}
+
+// parseWeight returns the `weight` option value. The default is 1.
+func parseWeight(options url.Values) (weight int, err error) {
+	v := options.Get("weight")
+	if v == "" {
+		return 1, nil
+	}
+	if weight, err = strconv.Atoi(v); err != nil || weight < 1 {
+		return 0, fmt.Errorf("bad weight %q: expected a positive integer", v)
+	}
+	return
+}
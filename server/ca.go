@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// CertExtensionAp is the certificate extension that marks the principals
+	// as access points.
+	CertExtensionAp = "ap@xssh"
+	// CertPrincipalApPrefix is the prefix of access point principals.
+	CertPrincipalApPrefix = "ap:"
+	// CertOptionSourceAddress is the critical option with comma separated
+	// list of client addresses in CIDR or IP format.
+	CertOptionSourceAddress = "source-address"
+)
+
+// CertAuthority checks user and access point certificates signed by trusted
+// CA keys.
+type CertAuthority struct {
+	Keys []gossh.PublicKey
+	// RevokedKeysFile is the OpenSSH key revocation list (KRL), generated by
+	// `ssh-keygen -k`. It revokes keys, CA keys and certificates by serial
+	// number or key id. The file is reloaded when changed.
+	RevokedKeysFile string
+
+	revoked revokedKeys
+	mu      sync.Mutex
+}
+
+// NewCertAuthority loads the CA public keys from authorized keys format files.
+func NewCertAuthority(revokedKeysFile string, keysFile ...string) (ca *CertAuthority, err error) {
+	ca = &CertAuthority{RevokedKeysFile: revokedKeysFile}
+	for _, pth := range keysFile {
+		var data []byte
+		if data, err = ioutil.ReadFile(pth); err != nil {
+			return nil, fmt.Errorf("read CA keys file %q failed: %v", pth, err)
+		}
+		for len(bytes.TrimSpace(data)) > 0 {
+			var key gossh.PublicKey
+			if key, _, _, data, err = gossh.ParseAuthorizedKey(data); err != nil {
+				return nil, fmt.Errorf("parse CA keys file %q failed: %v", pth, err)
+			}
+			ca.Keys = append(ca.Keys, key)
+		}
+	}
+	if len(ca.Keys) == 0 {
+		return nil, errors.New("no CA keys")
+	}
+	if revokedKeysFile != "" {
+		if err = ca.loadRevoked(); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func (ca *CertAuthority) IsAuthority(key gossh.PublicKey) bool {
+	data := key.Marshal()
+	for _, k := range ca.Keys {
+		if bytes.Equal(k.Marshal(), data) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check checks the user certificate presented by user from remoteAddr. The
+// certificate must be signed by a trusted CA and be valid for the user
+// principal. If principal has CertPrincipalApPrefix or the certificate has
+// CertExtensionAp extension, the user is an access point.
+func (ca *CertAuthority) Check(user string, remoteAddr net.Addr, cert *gossh.Certificate) (isAp bool, err error) {
+	if cert.CertType != gossh.UserCert {
+		return false, fmt.Errorf("certificate %q is not an user certificate", cert.KeyId)
+	}
+	if !ca.IsAuthority(cert.SignatureKey) {
+		return false, fmt.Errorf("certificate %q is not signed by a trusted CA", cert.KeyId)
+	}
+
+	var principal string
+	for _, p := range cert.ValidPrincipals {
+		if p == user {
+			principal = p
+		} else if p == CertPrincipalApPrefix+user {
+			principal = p
+			isAp = true
+		} else {
+			continue
+		}
+		break
+	}
+
+	if principal == "" {
+		return false, fmt.Errorf("certificate %q is not valid for user %q", cert.KeyId, user)
+	}
+
+	if _, ok := cert.Extensions[CertExtensionAp]; ok {
+		isAp = true
+	}
+
+	checker := &gossh.CertChecker{
+		SupportedCriticalOptions: []string{CertOptionSourceAddress},
+		IsRevoked:                ca.IsRevoked,
+	}
+
+	if err = checker.CheckCert(principal, cert); err != nil {
+		return false, fmt.Errorf("certificate %q: %v", cert.KeyId, err)
+	}
+
+	if addrs, ok := cert.CriticalOptions[CertOptionSourceAddress]; ok {
+		if err = checkSourceAddress(remoteAddr, addrs); err != nil {
+			return false, fmt.Errorf("certificate %q: %v", cert.KeyId, err)
+		}
+	}
+
+	return
+}
+
+// IsRevoked reports whether the certificate, the certified key or the signing
+// CA key has been revoked.
+func (ca *CertAuthority) IsRevoked(cert *gossh.Certificate) bool {
+	if ca.RevokedKeysFile == "" {
+		return false
+	}
+
+	if err := ca.loadRevoked(); err != nil {
+		log.Println("ERROR:", err)
+		// fail closed
+		return true
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	return ca.revoked.certRevoked(cert)
+}
+
+type revokedKeys struct {
+	*krl
+	modTime time.Time
+}
+
+func (ca *CertAuthority) loadRevoked() (err error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	var info os.FileInfo
+	if info, err = os.Stat(ca.RevokedKeysFile); err != nil {
+		return fmt.Errorf("stat of revoked keys file failed: %v", err)
+	}
+	if ca.revoked.krl != nil && info.ModTime().Equal(ca.revoked.modTime) {
+		return nil
+	}
+
+	var data []byte
+	if data, err = ioutil.ReadFile(ca.RevokedKeysFile); err != nil {
+		return fmt.Errorf("read revoked keys file failed: %v", err)
+	}
+	var k *krl
+	if k, err = parseKRL(data); err != nil {
+		return fmt.Errorf("parse revoked keys file failed: %v", err)
+	}
+	ca.revoked = revokedKeys{k, info.ModTime()}
+	return nil
+}
+
+func checkSourceAddress(addr net.Addr, sourceAddrs string) error {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("bad remote address %q", host)
+	}
+	for _, sourceAddr := range strings.Split(sourceAddrs, ",") {
+		sourceAddr = strings.TrimSpace(sourceAddr)
+		if strings.Contains(sourceAddr, "/") {
+			_, ipNet, err := net.ParseCIDR(sourceAddr)
+			if err != nil {
+				return fmt.Errorf("bad source address %q: %v", sourceAddr, err)
+			}
+			if ipNet.Contains(ip) {
+				return nil
+			}
+		} else if allowed := net.ParseIP(sourceAddr); allowed != nil && allowed.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("source address %q is not allowed", host)
+}
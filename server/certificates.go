@@ -0,0 +1,177 @@
+package server
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// DefaultCertificateExtensions are the extensions of user certificates
+// (same of `ssh-keygen`).
+var DefaultCertificateExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+type CertificateOptions struct {
+	KeyId                   string
+	Principals              []string
+	IsAp                    bool
+	ValidAfter, ValidBefore time.Time
+	CriticalOptions         map[string]string
+	Extensions              map[string]string
+	SignedBy                string
+}
+
+type CertificateRecord struct {
+	Serial                  uint64
+	KeyId                   string
+	IsAp                    bool
+	Principals              []string
+	Fingerprint             string
+	CAFingerprint           string
+	ValidAfter, ValidBefore time.Time
+	SignedBy                string
+	CreatedAt               time.Time
+}
+
+func (r CertificateRecord) String() (s string) {
+	s += fmt.Sprintf("#%d %s %s {%s} %s..%s", r.Serial, r.KeyId, r.Fingerprint, strings.Join(r.Principals, ","),
+		r.ValidAfter.Format(time.RFC3339), r.ValidBefore.Format(time.RFC3339))
+
+	var flags []string
+
+	if r.IsAp {
+		flags = append(flags, "AP")
+	}
+
+	if !r.ValidBefore.After(time.Now()) {
+		flags = append(flags, "EXPIRED")
+	}
+
+	if r.SignedBy != "" {
+		flags = append(flags, "SIGNED_BY="+r.SignedBy)
+	}
+
+	if len(flags) > 0 {
+		s += " [" + strings.Join(flags, ",") + "]"
+	}
+
+	return s
+}
+
+// Certificates signs user and access point certificates and keeps the
+// serial and audit records of them.
+type Certificates struct {
+	DB *DB
+}
+
+func NewCertificates(db *DB) *Certificates {
+	return &Certificates{DB: db}
+}
+
+// Sign signs key with CA signer. The certificate serial is the ID of the
+// audit record.
+func (s *Certificates) Sign(ca gossh.Signer, key gossh.PublicKey, opts *CertificateOptions) (cert *gossh.Certificate, err error) {
+	if len(opts.Principals) == 0 {
+		return nil, errors.New("no principals")
+	}
+	if !opts.ValidBefore.After(opts.ValidAfter) {
+		return nil, errors.New("bad validity interval")
+	}
+
+	cert = &gossh.Certificate{
+		Key:             key,
+		KeyId:           opts.KeyId,
+		CertType:        gossh.UserCert,
+		ValidPrincipals: opts.Principals,
+		ValidAfter:      uint64(opts.ValidAfter.Unix()),
+		ValidBefore:     uint64(opts.ValidBefore.Unix()),
+		Permissions: gossh.Permissions{
+			CriticalOptions: map[string]string{},
+			Extensions:      map[string]string{},
+		},
+	}
+
+	for name, value := range opts.CriticalOptions {
+		cert.CriticalOptions[name] = value
+	}
+	for name, value := range opts.Extensions {
+		cert.Extensions[name] = value
+	}
+	if opts.IsAp {
+		cert.Extensions[CertExtensionAp] = ""
+	}
+
+	var tx *sql.Tx
+	if tx, err = s.DB.Begin(); err != nil {
+		return nil, fmt.Errorf("DB Begin failed: %v", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var result sql.Result
+	if result, err = tx.Exec("INSERT INTO certificates (key_id, is_ap, principals, fingerprint, ca_fingerprint, "+
+		"valid_after, valid_before, signed_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		opts.KeyId, opts.IsAp, strings.Join(opts.Principals, ","), gossh.FingerprintSHA256(key),
+		gossh.FingerprintSHA256(ca.PublicKey()), opts.ValidAfter, opts.ValidBefore, opts.SignedBy); err != nil {
+		return nil, fmt.Errorf("DB Exec failed: %v", err)
+	}
+
+	var serial int64
+	if serial, err = result.LastInsertId(); err != nil {
+		return nil, fmt.Errorf("DB Get Last Insert ID failed: %v", err)
+	}
+	cert.Serial = uint64(serial)
+
+	if err = cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, fmt.Errorf("sign certificate failed: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("DB Commit failed: %v", err)
+	}
+	return
+}
+
+func (s *Certificates) List(cb func(i int, r *CertificateRecord) error) (err error) {
+	rows, err := s.DB.Query("SELECT serial, key_id, is_ap, principals, fingerprint, ca_fingerprint, valid_after, " +
+		"valid_before, signed_by, created_at FROM certificates ORDER BY serial ASC")
+	if err != nil {
+		return fmt.Errorf("DB Query failed: %v", err)
+	}
+
+	defer rows.Close()
+
+	for i := 1; rows.Next(); i++ {
+		var (
+			r          CertificateRecord
+			principals string
+		)
+		if err = rows.Scan(&r.Serial, &r.KeyId, &r.IsAp, &principals, &r.Fingerprint, &r.CAFingerprint,
+			&r.ValidAfter, &r.ValidBefore, &r.SignedBy, &r.CreatedAt); err != nil {
+			return fmt.Errorf("Scan certificate %d failed: %v", i, err)
+		}
+		r.Principals = strings.Split(principals, ",")
+		if err = cb(i, &r); err != nil {
+			if err == ErrStopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
@@ -4,7 +4,12 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 type DB struct {
@@ -30,12 +35,54 @@ create table if not exists users (
 	pub_key text
 );
 
+create table if not exists user_keys (
+	user VARCHAR(50) NOT NULL, 
+	name VARCHAR(50) NOT NULL, 
+	pub_key TEXT NOT NULL, 
+	expires_at TIMESTAMP, 
+	revoked BOOL NOT NULL DEFAULT false, 
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, 
+	last_used_at TIMESTAMP, 
+	PRIMARY KEY (user, name)
+);
+
+create table if not exists enrollment_tokens (
+	token_hash VARCHAR(64) NOT NULL PRIMARY KEY, 
+	user VARCHAR(50) NOT NULL, 
+	expires_at TIMESTAMP NOT NULL, 
+	created_by VARCHAR(50) NOT NULL DEFAULT '', 
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, 
+	used_at TIMESTAMP, 
+	used_addr VARCHAR(255), 
+	used_key VARCHAR(255)
+);
+
+create table if not exists certificates (
+	serial INTEGER PRIMARY KEY AUTOINCREMENT, 
+	key_id VARCHAR(255) NOT NULL, 
+	is_ap BOOL NOT NULL DEFAULT false, 
+	principals TEXT NOT NULL, 
+	fingerprint VARCHAR(255) NOT NULL, 
+	ca_fingerprint VARCHAR(255) NOT NULL, 
+	valid_after TIMESTAMP NOT NULL, 
+	valid_before TIMESTAMP NOT NULL, 
+	signed_by VARCHAR(50) NOT NULL DEFAULT '', 
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
 create table if not exists user_ap (
 	user VARCHAR(50) NOT NULL, 
 	ap VARCHAR(50) NOT NULL, 
 	PRIMARY KEY (user, ap)
 );
 
+create table if not exists service_acl (
+	user VARCHAR(50) NOT NULL, 
+	ap VARCHAR(50) NOT NULL, 
+	service VARCHAR(255) NOT NULL, 
+	PRIMARY KEY (user, ap, service)
+);
+
 create table if not exists load_balancers (
 	ap VARCHAR(50) NOT NULL, 
 	service VARCHAR(50) NOT NULL, 
@@ -50,15 +97,85 @@ create table if not exists load_balancers (
 	UNIQUE (public_addr),
 	UNIQUE (http_host, http_path)
 );
+
+create table if not exists lb_endpoints (
+	ap VARCHAR(50) NOT NULL, 
+	service VARCHAR(50) NOT NULL, 
+	addr VARCHAR(255) NOT NULL, 
+	weight INT NOT NULL DEFAULT 1, 
+	healthy BOOL NOT NULL DEFAULT true, 
+	last_check_at TIMESTAMP, 
+	last_error TEXT NOT NULL DEFAULT '', 
+	updated_at TIMESTAMP NOT NULL, 
+	PRIMARY KEY (ap, service, addr)
+);
+
+create table if not exists tls_certificates (
+	host VARCHAR(255) NOT NULL PRIMARY KEY, 
+	cert TEXT NOT NULL, 
+	key TEXT NOT NULL, 
+	not_after TIMESTAMP NOT NULL, 
+	created_at TIMESTAMP NOT NULL
+);
+
+create table if not exists public_ports (
+	addr VARCHAR(255) NOT NULL PRIMARY KEY, 
+	ap VARCHAR(50) NOT NULL, 
+	service VARCHAR(50) NOT NULL, 
+	allow TEXT NOT NULL DEFAULT '', 
+	created_at TIMESTAMP NOT NULL
+);
+
+create table if not exists acme_cache (
+	key VARCHAR(255) NOT NULL PRIMARY KEY, 
+	data BLOB NOT NULL, 
+	updated_at TIMESTAMP NOT NULL
+);
 `
 	_, err = db.Exec(sqlStmt)
 	if err != nil {
 		log.Fatalf("%q: %s\n", err, sqlStmt)
 	}
+	for _, column := range addedColumns {
+		sqlStmt = "ALTER TABLE " + column[0] + " ADD COLUMN " + column[1]
+		if _, err = db.Exec(sqlStmt); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			log.Fatalf("%q: %s\n", err, sqlStmt)
+		}
+	}
 	s.DB = db
 	return s
 }
 
+// addedColumns is the columns (table name and definition) added to existing
+// tables. They are created if not exists.
+var addedColumns = [][2]string{
+	{"load_balancers", "balancer VARCHAR(50) NOT NULL DEFAULT ''"},
+	{"load_balancers", "sticky_sessions BOOL NOT NULL DEFAULT false"},
+	{"load_balancers", "health_check VARCHAR(10) NOT NULL DEFAULT ''"},
+	{"load_balancers", "health_check_path VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"load_balancers", "health_check_interval INT NOT NULL DEFAULT 10"},
+	{"load_balancers", "health_check_timeout INT NOT NULL DEFAULT 2"},
+	{"load_balancers", "healthy_threshold INT NOT NULL DEFAULT 2"},
+	{"load_balancers", "unhealthy_threshold INT NOT NULL DEFAULT 3"},
+	{"load_balancers", "max_retries INT NOT NULL DEFAULT 0"},
+	{"load_balancers", "outlier_errors INT NOT NULL DEFAULT 0"},
+	{"load_balancers", "outlier_ejection_time INT NOT NULL DEFAULT 30"},
+	{"lb_endpoints", "ejected_until TIMESTAMP"},
+	{"lb_endpoints", "draining BOOL NOT NULL DEFAULT false"},
+	{"load_balancers", "strip_prefix BOOL NOT NULL DEFAULT false"},
+	{"load_balancers", "path_rewrite VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"load_balancers", "path_rewrite_replacement VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"load_balancers", "upstream_host VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"load_balancers", "http_headers TEXT NOT NULL DEFAULT ''"},
+	{"load_balancers", "http_max_idle_conns INT NOT NULL DEFAULT 8"},
+	{"load_balancers", "http_idle_timeout INT NOT NULL DEFAULT 90"},
+	{"load_balancers", "http_timeout INT NOT NULL DEFAULT 60"},
+	{"load_balancers", "tls_sni_host VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"load_balancers", "public_udp_addr VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"load_balancers", "allow_cidrs TEXT NOT NULL DEFAULT ''"},
+	{"load_balancers", "deny_cidrs TEXT NOT NULL DEFAULT ''"},
+}
+
 func (s *DB) Close() error {
 	if s.DB != nil {
 		return s.DB.Close()
@@ -74,12 +191,35 @@ func (a *HttpUsers) Empty() bool {
 	return a.users == nil || len(a.users) == 0
 }
 
-func (a *HttpUsers) Set(user, password string) *HttpUsers {
+// Set sets the bcrypt hash of user password.
+func (a *HttpUsers) Set(user, password string) (err error) {
+	var hash string
+	if hash, err = hashPassword(password); err != nil {
+		return
+	}
+	return a.SetHash(user, hash)
+}
+
+// SetHash sets the password hash of user. Accepts the htpasswd bcrypt,
+// APR1-MD5 and SHA1 formats.
+func (a *HttpUsers) SetHash(user, hash string) error {
+	if !isHtpasswdHash(hash) {
+		return fmt.Errorf("unsupported password hash format of user %q", user)
+	}
 	if a.users == nil {
 		a.users = map[string]string{}
 	}
-	a.users[user] = password
-	return a
+	a.users[user] = hash
+	return nil
+}
+
+// Names returns the sorted user names.
+func (a *HttpUsers) Names() (names []string) {
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
 }
 
 func (a *HttpUsers) Remove(user ...string) *HttpUsers {
@@ -123,12 +263,25 @@ func (a *HttpUsers) Scan(src interface{}) (err error) {
 	return nil
 }
 
+// Match checks the user password in constant time.
 func (a *HttpUsers) Match(user, password string) bool {
-	if a.users == nil {
-		return false
-	}
-	if pwd, ok := a.users[user]; !ok || password != pwd {
+	stored, ok := a.users[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
 		return false
 	}
-	return true
+	return checkPassword(stored, password)
+}
+
+// Hash returns the stored password hash of user.
+func (a *HttpUsers) Hash(user string) (hash string, ok bool) {
+	hash, ok = a.users[user]
+	return
+}
+
+// NeedsRehash reports whether the password of user is not stored as bcrypt
+// hash (legacy plain text or imported htpasswd formats).
+func (a *HttpUsers) NeedsRehash(user string) bool {
+	stored, ok := a.users[user]
+	return ok && !isPasswordHashed(stored)
 }
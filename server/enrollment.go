@@ -0,0 +1,181 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var ErrInvalidEnrollmentToken = errors.New("invalid enrollment token")
+
+type Enrollment struct {
+	ID        string
+	User      string
+	ExpiresAt time.Time
+	CreatedBy string
+	CreatedAt time.Time
+	UsedAt    *time.Time
+	UsedAddr  *string
+	UsedKey   *string
+}
+
+func (e Enrollment) String() (s string) {
+	s += e.ID + " " + e.User + " created at " + e.CreatedAt.Format(time.RFC3339)
+
+	var flags []string
+
+	if e.CreatedBy != "" {
+		flags = append(flags, "CREATED_BY="+e.CreatedBy)
+	}
+
+	if e.UsedAt != nil {
+		flags = append(flags, "USED="+e.UsedAt.Format(time.RFC3339))
+		if e.UsedAddr != nil {
+			flags = append(flags, "ADDR="+*e.UsedAddr)
+		}
+		if e.UsedKey != nil {
+			flags = append(flags, "KEY="+*e.UsedKey)
+		}
+	} else if !e.ExpiresAt.After(time.Now()) {
+		flags = append(flags, "EXPIRED")
+	} else {
+		flags = append(flags, "PENDING", "EXPIRES="+e.ExpiresAt.Format(time.RFC3339))
+	}
+
+	s += " [" + strings.Join(flags, ",") + "]"
+	return s
+}
+
+func hashEnrollmentToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Enroll creates an one-time enrollment token to bind a new key to user. Only
+// the token hash is stored.
+func (s *Users) Enroll(user string, ttl time.Duration, createdBy string) (token string, err error) {
+	if _, err = s.IsAp(user); err != nil {
+		return
+	}
+
+	var b = make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token failed: %v", err)
+	}
+	token = hex.EncodeToString(b)
+
+	_, err = s.DB.Exec("INSERT INTO enrollment_tokens (token_hash, user, expires_at, created_by) VALUES (?, ?, ?, ?)",
+		hashEnrollmentToken(token), user, time.Now().Add(ttl), createdBy)
+	if err != nil {
+		return "", fmt.Errorf("DB Exec failed: %v", err)
+	}
+	return
+}
+
+// HasEnrollment reports whether user has a pending enrollment token.
+func (s *Users) HasEnrollment(user string) (ok bool, err error) {
+	var rows *sql.Rows
+
+	rows, err = s.DB.Query("select 1 from enrollment_tokens where user = ? and used_at is null and expires_at > ?", user, time.Now())
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+// ConsumeEnrollment consumes the enrollment token of user and binds the key
+// (in authorized keys format) to it.
+func (s *Users) ConsumeEnrollment(user, token, key, remoteAddr string) (keyName string, err error) {
+	pubKey, _, _, _, err := gossh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return "", fmt.Errorf("parse public key failed: %v", err)
+	}
+
+	var tx *sql.Tx
+	if tx, err = s.DB.Begin(); err != nil {
+		return "", fmt.Errorf("DB Begin failed: %v", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var (
+		now    = time.Now()
+		hash   = hashEnrollmentToken(strings.TrimSpace(token))
+		result sql.Result
+		af     int64
+	)
+
+	if result, err = tx.Exec("UPDATE enrollment_tokens SET used_at = ?, used_addr = ?, used_key = ? "+
+		"WHERE token_hash = ? AND user = ? AND used_at IS NULL AND expires_at > ?",
+		now, remoteAddr, gossh.FingerprintSHA256(pubKey), hash, user, now); err != nil {
+		return "", fmt.Errorf("DB Exec failed: %v", err)
+	} else if af, err = result.RowsAffected(); err != nil {
+		return "", fmt.Errorf("DB Get Affcted Rows failed: %v", err)
+	} else if af == 0 {
+		return "", ErrInvalidEnrollmentToken
+	}
+
+	// the token is used once, so its ID (see Enrollment.ID) makes the name
+	// unique
+	keyName = "enrolled-" + now.Format("20060102150405") + "-" + hash[0:8]
+
+	if _, err = tx.Exec("INSERT INTO user_keys (user, name, pub_key, last_used_at) VALUES (?, ?, ?, ?)",
+		user, keyName, string(gossh.MarshalAuthorizedKey(pubKey)), now); err != nil {
+		return "", fmt.Errorf("DB Exec failed: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", fmt.Errorf("DB Commit failed: %v", err)
+	}
+	return
+}
+
+func (s *Users) Enrollments(user string, cb func(i int, e *Enrollment) error) (err error) {
+	var (
+		where string
+		args  []interface{}
+	)
+
+	if user != "" {
+		where = " WHERE user = ?"
+		args = append(args, user)
+	}
+
+	rows, err := s.DB.Query("SELECT token_hash, user, expires_at, created_by, created_at, used_at, used_addr, used_key "+
+		"FROM enrollment_tokens"+where+" ORDER BY created_at ASC", args...)
+	if err != nil {
+		return fmt.Errorf("DB Query failed: %v", err)
+	}
+
+	defer rows.Close()
+
+	for i := 1; rows.Next(); i++ {
+		var e Enrollment
+		if err = rows.Scan(&e.ID, &e.User, &e.ExpiresAt, &e.CreatedBy, &e.CreatedAt, &e.UsedAt, &e.UsedAddr, &e.UsedKey); err != nil {
+			return fmt.Errorf("Scan enrollment %d failed: %v", i, err)
+		}
+		e.ID = e.ID[0:8]
+		if err = cb(i, &e); err != nil {
+			if err == ErrStopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
@@ -55,7 +55,7 @@ func (srv *Server) newSession(apName string, conn *ssh.ServerConn) (s *session,
 
 	sshConfig := &gossh.ClientConfig{
 		User:            s.conn.User(),
-		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		HostKeyCallback: apLn.HostKeyCallback(srv.register.ApKnownHosts),
 	}
 
 	s.client, err = gossh.Dial("tcp", apLn.Addr().String(), sshConfig)
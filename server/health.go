@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HealthCheckTCP  = "tcp"
+	HealthCheckHTTP = "http"
+)
+
+// HealthCheckConfig is the active health check configuration of load balancer
+// endpoints.
+type HealthCheckConfig struct {
+	// Type is the check type: HealthCheckTCP or HealthCheckHTTP.
+	Type string
+	// Path is the HTTP GET path.
+	Path string
+	// Host is the HTTP Host header.
+	Host               string
+	Interval, Timeout  time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// NewHealthCheckConfig returns the health check configuration of load balancer
+// or nil if is disabled.
+func NewHealthCheckConfig(lb *LoadBalancer) (cfg *HealthCheckConfig, err error) {
+	switch lb.HealthCheck {
+	case "":
+		return nil, nil
+	case HealthCheckTCP, HealthCheckHTTP:
+	default:
+		return nil, fmt.Errorf("unknown health check %q. Available: %s, %s", lb.HealthCheck, HealthCheckTCP, HealthCheckHTTP)
+	}
+	cfg = &HealthCheckConfig{
+		Type:               lb.HealthCheck,
+		Path:               lb.HealthCheckPath,
+		Host:               lb.UpstreamHost,
+		Interval:           time.Duration(lb.HealthCheckInterval) * time.Second,
+		Timeout:            time.Duration(lb.HealthCheckTimeout) * time.Second,
+		HealthyThreshold:   lb.HealthyThreshold,
+		UnhealthyThreshold: lb.UnhealthyThreshold,
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	if cfg.Host == "" && !strings.HasPrefix(lb.httpHost(), "*") {
+		cfg.Host = lb.httpHost()
+	}
+	if cfg.Host == "" {
+		cfg.Host = lb.Service
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Timeout <= 0 || cfg.Timeout > cfg.Interval {
+		cfg.Timeout = cfg.Interval
+	}
+	if cfg.HealthyThreshold < 1 {
+		cfg.HealthyThreshold = 1
+	}
+	if cfg.UnhealthyThreshold < 1 {
+		cfg.UnhealthyThreshold = 1
+	}
+	return
+}
+
+// EndPointHealth is the health state of load balancer endpoint.
+type EndPointHealth struct {
+	Unhealthy bool
+	// Passes and Fails are the consecutive check results.
+	Passes, Fails int
+	LastCheckAt   time.Time
+	LastError     string
+	// Errors is the consecutive traffic errors count (passive outlier
+	// detection).
+	Errors int
+	// EjectedUntil is the end time of outlier ejection.
+	EjectedUntil time.Time
+}
+
+type healthChecker struct {
+	node *Node
+	cfg  HealthCheckConfig
+	stop chan struct{}
+}
+
+func newHealthChecker(n *Node, cfg HealthCheckConfig) *healthChecker {
+	hc := &healthChecker{node: n, cfg: cfg, stop: make(chan struct{})}
+	go hc.run()
+	return hc
+}
+
+func (hc *healthChecker) Stop() {
+	close(hc.stop)
+}
+
+func (hc *healthChecker) run() {
+	ticker := time.NewTicker(hc.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		hc.checkAll()
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hc *healthChecker) checkAll() {
+	var wg sync.WaitGroup
+	for _, sl := range hc.node.endPoints() {
+		wg.Add(1)
+		go func(sl *NodeServiceListener) {
+			defer wg.Done()
+			hc.check(sl)
+		}(sl)
+	}
+	wg.Wait()
+}
+
+func (hc *healthChecker) check(sl *NodeServiceListener) {
+	err := hc.probe(sl)
+	select {
+	case <-hc.stop:
+		return
+	default:
+	}
+	if changed := sl.setHealthCheckResult(err, hc.cfg.HealthyThreshold, hc.cfg.UnhealthyThreshold); changed {
+		if err != nil {
+			log.Println(hc.node.String(), "EP{"+sl.key+"}: ejected by health check:", err.Error())
+		} else {
+			log.Println(hc.node.String(), "EP{"+sl.key+"}: readmitted by health check")
+		}
+		hc.node.nodes.saveEndPoint(hc.node, sl)
+	}
+}
+
+// probe checks the endpoint through the AP. If the AP reports the backend
+// dial status, the TCP check is the dial result. Otherwise, the AP closes the
+// connection if it can't connect to backend, so the TCP check fails if the
+// connection is closed before the timeout and passes if it is still open (the
+// backend may wait for the client to speak first) or the backend sends data.
+func (hc *healthChecker) probe(sl *NodeServiceListener) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.cfg.Timeout)
+	defer cancel()
+
+	conn, err := sl.dial(ctx, "health-check")
+	if err != nil {
+		return fmt.Errorf("dial failed: %v", err)
+	}
+	if hc.cfg.Type == HealthCheckTCP && sl.DialStatus {
+		conn.Close()
+		return nil
+	}
+
+	var done = make(chan error, 1)
+	go func() {
+		done <- hc.roundTrip(conn)
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		if hc.cfg.Type != HealthCheckTCP {
+			err = fmt.Errorf("timeout after %s", hc.cfg.Timeout)
+		}
+	}
+	conn.Close()
+	return
+}
+
+func (hc *healthChecker) roundTrip(conn io.ReadWriter) (err error) {
+	if hc.cfg.Type == HealthCheckTCP {
+		if _, err = conn.Read(make([]byte, 1)); err != nil {
+			return fmt.Errorf("connection closed: %v", err)
+		}
+		return nil
+	}
+
+	if _, err = fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: xssh-health-check\r\nConnection: close\r\n\r\n",
+		hc.cfg.Path, hc.cfg.Host); err != nil {
+		return fmt.Errorf("write request failed: %v", err)
+	}
+	var resp *http.Response
+	if resp, err = http.ReadResponse(bufio.NewReader(conn), nil); err != nil {
+		return fmt.Errorf("read response failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("bad HTTP status %q", resp.Status)
+	}
+	return nil
+}
@@ -3,22 +3,50 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
-
-	"golang.org/x/net/http2"
 )
 
 var (
 	errUnauthorised = errors.New("unauthorised")
 )
 
+// DefaultHttpHost is the HTTP host of load balancers that serve the requests
+// not routed by other hosts.
+const DefaultHttpHost = "*"
+
 type Dialer func(ctx context.Context, remoteAddr string) (con net.Conn, err error)
 
+// isPathRegexp reports whether the load balancer HTTP path is a regular
+// expression route: `~` followed by the expression. Example: `~^/api/v[0-9]+/`.
+func isPathRegexp(pth string) bool {
+	return strings.HasPrefix(pth, "~")
+}
+
+// compilePathRegexp compiles the expression of regular expression route.
+func compilePathRegexp(pth string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pth[1:])
+	if err != nil {
+		return nil, fmt.Errorf("bad HTTP path regexp %q: %v", pth[1:], err)
+	}
+	return re, nil
+}
+
+// requestHost returns the lower case request host without port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
 func cleanPth(pth string) string {
 	if pth == "" {
 		pth = "/"
@@ -34,37 +62,85 @@ func cleanPth(pth string) string {
 
 type LB struct {
 	*LoadBalancer
-	Node *Node
+	Node    *Node
+	re      *regexp.Regexp
+	rewrite *httpRewrite
+}
+
+// newLB creates the HTTP route of load balancer node.
+func newLB(lb *LoadBalancer, n *Node) (l *LB, err error) {
+	l = &LB{LoadBalancer: lb, Node: n}
+	if isPathRegexp(lb.HttpPath) {
+		if l.re, err = compilePathRegexp(lb.HttpPath); err != nil {
+			return nil, err
+		}
+	}
+	if l.rewrite, err = newHttpRewrite(lb); err != nil {
+		return nil, err
+	}
+	return
 }
 
 type HostPaths struct {
-	host   string
-	paths  map[string]*LB
-	sorted []string
-	mu     sync.RWMutex
+	host    string
+	paths   map[string]*LB
+	sorted  []string
+	regexps []string
+	mu      sync.RWMutex
 }
 
 func (hp *HostPaths) sort() {
-	var sorted []string
+	var sorted, regexps []string
 	for pth := range hp.paths {
-		sorted = append(sorted, pth)
+		if isPathRegexp(pth) {
+			regexps = append(regexps, pth)
+		} else {
+			sorted = append(sorted, pth)
+		}
 	}
 	sort.Slice(sorted, func(i, j int) bool {
 		return sorted[i] > sorted[j]
 	})
-	hp.sorted = sorted
+	sort.Strings(regexps)
+	hp.sorted, hp.regexps = sorted, regexps
 }
 
 func (hp *HostPaths) Get(pth string) (lb *LB, ok bool) {
 	hp.mu.RLock()
 	defer hp.mu.RUnlock()
-	if len(hp.sorted) == 0 {
+	if len(hp.paths) == 0 {
 		return
 	}
 	lb, ok = hp.paths[pth]
 	return
 }
 
+// Match returns the load balancer of request path. The regexp routes are
+// matched first, ordered by expression, and then the longest path prefix.
+func (hp *HostPaths) Match(r *http.Request) *LB {
+	hp.mu.RLock()
+	defer hp.mu.RUnlock()
+
+	for _, pth := range hp.regexps {
+		if lb := hp.paths[pth]; lb.re.MatchString(r.URL.Path) {
+			return lb
+		}
+	}
+
+	uriSlash := r.RequestURI
+	if !strings.HasSuffix(uriSlash, "/") {
+		uriSlash += "/"
+	}
+	for _, pth := range hp.sorted {
+		if strings.HasPrefix(r.RequestURI, pth) || uriSlash == pth {
+			return hp.paths[pth]
+		}
+	}
+	return nil
+}
+
+// Set mounts the node to load balancer path. If the path is mounted by same
+// node, updates the load balancer configuration.
 func (hp *HostPaths) Set(lb *LoadBalancer, n *Node) {
 	hp.mu.Lock()
 	defer hp.mu.Unlock()
@@ -72,10 +148,19 @@ func (hp *HostPaths) Set(lb *LoadBalancer, n *Node) {
 		hp.paths = map[string]*LB{}
 	}
 	var pth = lb.HttpPath
-	if _, ok := hp.paths[pth]; !ok {
-		hp.paths[pth] = &LB{lb, n}
+	l, err := newLB(lb, n)
+	if err != nil {
+		log.Println("HTTP:", "`"+n.Name()+"`", "mount to `"+hp.host+pth+"` failed:", err.Error())
+		return
+	}
+	if old, ok := hp.paths[pth]; !ok {
+		hp.paths[pth] = l
 		log.Println("HTTP:", "`"+n.Name()+"`", "mounted to `"+hp.host+pth+"`")
 		hp.sort()
+	} else if old.Node == n {
+		hp.paths[pth] = l
+	} else {
+		log.Println("HTTP:", "`"+n.Name()+"`", "mount to `"+hp.host+pth+"` failed: used by `"+old.Node.Name()+"`")
 	}
 }
 
@@ -109,6 +194,39 @@ func (h *HttpHosts) Get(host string) (pths *HostPaths, ok bool) {
 	return
 }
 
+// Match returns the load balancer of request host and path. The exact host is
+// matched first and then the wildcard hosts, from the most specific
+// (`*.apps.example.com`) to the least specific (`*.com`). The wildcard
+// matches one or more labels. DefaultHttpHost is not matched, see Default.
+func (h *HttpHosts) Match(r *http.Request) *LB {
+	var (
+		host = requestHost(r)
+		name = host
+	)
+	for {
+		if pths, ok := h.Get(name); ok {
+			if lb := pths.Match(r); lb != nil {
+				return lb
+			}
+		}
+		pos := strings.IndexByte(host, '.')
+		if pos == -1 {
+			return nil
+		}
+		host = host[pos+1:]
+		name = "*." + host
+	}
+}
+
+// Default returns the load balancer of DefaultHttpHost that matches the
+// request path.
+func (h *HttpHosts) Default(r *http.Request) *LB {
+	if pths, ok := h.Get(DefaultHttpHost); ok {
+		return pths.Match(r)
+	}
+	return nil
+}
+
 func (h *HttpHosts) Register(host string) *HostPaths {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -151,13 +269,13 @@ func (h *HttpHosts) Remove(host string, pth ...string) {
 	}
 }
 
-type httpConnPool struct {
-	con *http2.ClientConn
-}
-
-func (cp *httpConnPool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
-	return cp.con, nil
-}
-
-func (cp *httpConnPool) MarkDead(*http2.ClientConn) {
+// Unmount removes the path of host if it is mounted by node n.
+func (h *HttpHosts) Unmount(host, pth string, n *Node) {
+	pths, ok := h.Get(host)
+	if !ok {
+		return
+	}
+	if lb, ok := pths.Get(pth); ok && lb.Node == n {
+		h.Remove(host, pth)
+	}
 }
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	HeaderActionAdd    = "add"
+	HeaderActionSet    = "set"
+	HeaderActionRemove = "remove"
+)
+
+// HeaderTemplateVars is the variables of header value templates. The value
+// `{name}` is replaced by variable value. Example: `X-Client: {client_ip}`.
+var HeaderTemplateVars = []string{"client_ip", "ap", "service", "user", "request_id", "host", "scheme"}
+
+// HeaderRule is a header change of load balancer HTTP route.
+type HeaderRule struct {
+	// Response is true for response headers, otherwise changes the upstream
+	// request headers.
+	Response bool   `json:"response,omitempty"`
+	Action   string `json:"action"`
+	Name     string `json:"name"`
+	// Value is the header value template. See HeaderTemplateVars.
+	Value string `json:"value,omitempty"`
+}
+
+func (r *HeaderRule) Validate() error {
+	switch r.Action {
+	case HeaderActionAdd, HeaderActionSet, HeaderActionRemove:
+	default:
+		return fmt.Errorf("unknown header action %q. Available: %s, %s, %s", r.Action, HeaderActionAdd,
+			HeaderActionSet, HeaderActionRemove)
+	}
+	if r.Name == "" || strings.ContainsAny(r.Name, ": \t\r\n") {
+		return fmt.Errorf("bad header name %q", r.Name)
+	}
+	if strings.ContainsAny(r.Value, "\r\n") {
+		return fmt.Errorf("bad value of header %q: has line breaks", r.Name)
+	}
+	return nil
+}
+
+func (r HeaderRule) String() string {
+	str := "request"
+	if r.Response {
+		str = "response"
+	}
+	str += " " + r.Action + " " + http.CanonicalHeaderKey(r.Name)
+	if r.Action != HeaderActionRemove {
+		str += ": " + r.Value
+	}
+	return str
+}
+
+// HeaderRules is the header changes of load balancer HTTP route, applied in
+// order.
+type HeaderRules []HeaderRule
+
+func (rules HeaderRules) equal(other HeaderRules) bool {
+	if len(rules) != len(other) {
+		return false
+	}
+	for i := range rules {
+		if rules[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// apply applies the request or response rules to h.
+func (rules HeaderRules) apply(h http.Header, response bool, vars *strings.Replacer) {
+	for _, r := range rules {
+		if r.Response != response {
+			continue
+		}
+		switch r.Action {
+		case HeaderActionAdd:
+			h.Add(r.Name, vars.Replace(r.Value))
+		case HeaderActionSet:
+			h.Set(r.Name, vars.Replace(r.Value))
+		case HeaderActionRemove:
+			h.Del(r.Name)
+		}
+	}
+}
+
+func (rules *HeaderRules) Value() (v driver.Value, err error) {
+	if len(*rules) == 0 {
+		return "", nil
+	}
+	var data []byte
+	if data, err = json.MarshalIndent(*rules, "", "  "); err != nil {
+		return
+	}
+	v = data
+	return
+}
+
+func (rules *HeaderRules) Scan(src interface{}) (err error) {
+	*rules = nil
+	switch t := src.(type) {
+	case []byte:
+		if len(t) > 0 {
+			return json.Unmarshal(t, rules)
+		}
+	case string:
+		if t != "" {
+			return json.Unmarshal([]byte(t), rules)
+		}
+	}
+	return nil
+}
+
+// headerVars returns the replacer of header value templates. The client IP
+// is resolved through the TrustedProxies (see httpClientAddr).
+func (srv *Server) headerVars(lb *LB, r *http.Request, user, requestID, scheme string) *strings.Replacer {
+	return strings.NewReplacer(
+		"{client_ip}", clientIP(srv.httpClientAddr(r)),
+		"{ap}", lb.Ap,
+		"{service}", lb.Service,
+		"{user}", user,
+		"{request_id}", requestID,
+		"{host}", r.Host,
+		"{scheme}", scheme,
+	)
+}
+
+// requestID returns the X-Request-Id header value of request or a new random
+// ID.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" {
+		return id
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
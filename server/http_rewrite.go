@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// httpRewrite rewrites the upstream request of load balancer HTTP route and
+// its response.
+type httpRewrite struct {
+	// prefix is the stripped public path prefix, without trailing slash.
+	prefix      string
+	re          *regexp.Regexp
+	replacement string
+	host        string
+}
+
+// newHttpRewrite returns the rewrite of load balancer or nil if it has no
+// rewrite options.
+func newHttpRewrite(lb *LoadBalancer) (rw *httpRewrite, err error) {
+	rw = &httpRewrite{replacement: lb.PathRewriteReplacement, host: lb.UpstreamHost}
+	if lb.StripPrefix && !isPathRegexp(lb.HttpPath) {
+		rw.prefix = strings.TrimSuffix(lb.HttpPath, "/")
+	}
+	if lb.PathRewrite != "" {
+		if rw.re, err = regexp.Compile(lb.PathRewrite); err != nil {
+			return nil, fmt.Errorf("bad path rewrite regexp %q: %v", lb.PathRewrite, err)
+		}
+	}
+	if rw.prefix == "" && rw.re == nil && rw.host == "" {
+		return nil, nil
+	}
+	return
+}
+
+func (rw *httpRewrite) path(pth string) string {
+	if rw.prefix != "" && hasPathPrefix(pth, rw.prefix) {
+		if pth = pth[len(rw.prefix):]; pth == "" {
+			pth = "/"
+		}
+	}
+	if rw.re != nil {
+		pth = rw.re.ReplaceAllString(pth, rw.replacement)
+	}
+	return pth
+}
+
+// Request rewrites the upstream request path and host. The r.URL must not be
+// shared with client request.
+func (rw *httpRewrite) Request(r *http.Request) {
+	if rw.prefix != "" || rw.re != nil {
+		r.URL.Path = rw.path(r.URL.Path)
+		r.URL.RawPath = ""
+		if rw.prefix != "" {
+			r.Header.Set("X-Forwarded-Prefix", rw.prefix)
+		}
+	}
+	if rw.host != "" {
+		r.Host = rw.host
+	}
+}
+
+// Response rewrites the Location and Set-Cookie headers of upstream response
+// back to the public host and prefix.
+func (rw *httpRewrite) Response(resp *http.Response, publicHost, scheme string) {
+	if loc := resp.Header.Get("Location"); loc != "" {
+		resp.Header.Set("Location", rw.location(loc, publicHost, scheme))
+	}
+	if rw.prefix == "" {
+		return
+	}
+	for i, cookie := range resp.Header["Set-Cookie"] {
+		resp.Header["Set-Cookie"][i] = rw.cookie(cookie)
+	}
+}
+
+func (rw *httpRewrite) location(loc, publicHost, scheme string) string {
+	u, err := url.Parse(loc)
+	if err != nil {
+		return loc
+	}
+	if u.Host != "" {
+		if rw.host != "" && strings.EqualFold(u.Host, rw.host) {
+			u.Host, u.Scheme = publicHost, scheme
+		} else if !strings.EqualFold(u.Host, publicHost) {
+			// external
+			return loc
+		}
+	}
+	if rw.prefix != "" && strings.HasPrefix(u.Path, "/") && !hasPathPrefix(u.Path, rw.prefix) {
+		u.Path = rw.prefix + u.Path
+		u.RawPath = ""
+	}
+	return u.String()
+}
+
+// cookie prefixes the Path attribute of Set-Cookie header value.
+func (rw *httpRewrite) cookie(cookie string) string {
+	attrs := strings.Split(cookie, ";")
+	for i, attr := range attrs[1:] {
+		attr = strings.TrimSpace(attr)
+		if len(attr) > 5 && strings.EqualFold(attr[:5], "path=") && strings.HasPrefix(attr[5:], "/") &&
+			!hasPathPrefix(attr[5:], rw.prefix) {
+			attrs[i+1] = " Path=" + rw.prefix + attr[5:]
+		}
+	}
+	return strings.Join(attrs, ";")
+}
+
+// hasPathPrefix reports whether the pth is the prefix or a sub path of it.
+func hasPathPrefix(pth, prefix string) bool {
+	return pth == prefix || strings.HasPrefix(pth, prefix+"/")
+}
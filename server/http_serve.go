@@ -1,7 +1,6 @@
 package server
 
 import (
-	"context"
 	"fmt"
 	"html/template"
 	"io"
@@ -12,14 +11,16 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"reflect"
 	"strings"
 
 	"github.com/moisespsena-go/httpu"
-	"golang.org/x/net/http2"
 	"golang.org/x/net/websocket"
 )
 
+// StickySessionCookie is the cookie name of HTTP sticky sessions. The value
+// is the endpoint ID.
+const StickySessionCookie = "XSSH_LB"
+
 func (srv *Server) serveLocal(w http.ResponseWriter, r *http.Request) {
 	auth := r.Header.Get("Authorization")
 	if auth == "" {
@@ -88,6 +89,16 @@ func (srv *Server) serveLocal(w http.ResponseWriter, r *http.Request) {
 	}).ServeHTTP(w, r)
 }
 
+// roundTripError writes the RoundTrip error response.
+func roundTripError(w http.ResponseWriter, err error) {
+	if err == errUnauthorised {
+		w.Header().Set("WWW-Authenticate", "Basic realm=\"User Visible Realm\"")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	} else {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
 func (srv *Server) renderOrNotFound(w http.ResponseWriter, r *http.Request, status int, fileName ...string) {
 	for _, fileName := range fileName {
 		fileName = filepath.Join("www", fileName)
@@ -134,60 +145,47 @@ func (srv *Server) renderOrNotFound(w http.ResponseWriter, r *http.Request, stat
 func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	url := r.URL.String()
 	log.Println("HTTP:", r.Host, r.Proto, "<"+r.RemoteAddr+">", "`"+url+"`", "connected")
+	sw, w := newStatusWriter(w)
 	defer func() {
-		code := reflect.ValueOf(w).Elem().FieldByName("status").Int()
-		log.Println("HTTP:", r.Host, "<"+r.RemoteAddr+">", code, "`"+url+"`", "done")
+		log.Println("HTTP:", r.Host, "<"+r.RemoteAddr+">", sw.Status(), "`"+url+"`", "done")
 	}()
 
-	if r.RequestURI == "/" || r.RequestURI == "" {
-		srv.renderOrNotFound(w, r, 200, "index")
+	if srv.serveACMEChallenge(w, r) {
 		return
 	}
 
-	if isWebsocketRequest(r) {
+	if isTunnelRequest(r) {
 		srv.serveLocal(w, r)
 		return
 	}
-	var (
-		lb *LB
 
-		host     = strings.SplitN(r.Host, ":", 2)[0]
-		uriSlash = r.RequestURI
-	)
-
-	if !strings.HasSuffix(uriSlash, "/") {
-		uriSlash += "/"
+	lb, ln := srv.httpRoute(r)
+	if ln != nil {
+		ln.httpProxy().ServeHTTP(w, r)
+		return
 	}
-
-	if pths, ok := srv.HttpHosts.Get(host); ok {
-		for _, pth := range pths.sorted {
-			if strings.HasPrefix(r.RequestURI, pth) {
-				if lb_, ok := pths.Get(pth); ok {
-					lb = lb_
-					break
-				}
-			} else if uriSlash == pth {
-				if lb_, ok := pths.Get(pth); ok {
-					lb = lb_
-					break
-				}
-			}
+	if lb == nil {
+		if r.RequestURI == "/" || r.RequestURI == "" {
+			srv.renderOrNotFound(w, r, 200, "index")
+		} else {
+			srv.renderOrNotFound(w, r, 404, r.RequestURI, "not_found", "index")
 		}
+		return
 	}
 
-	if lb == nil {
-		srv.renderOrNotFound(w, r, 404, r.RequestURI, "not_found", "index")
+	if lb.Node != nil && !lb.Node.allowSource(lb.LoadBalancer, srv.httpClientAddr(r)) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if isUpgradeRequest(r) {
+		srv.serveUpgrade(w, r, lb)
 		return
 	}
 
 	resp, err := srv.RoundTrip(lb, r)
 	if err != nil {
-		if err == errUnauthorised {
-			w.Header().Set("WWW-Authenticate", "Basic realm=\"User Visible Realm\"")
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-		} else {
-			http.Error(w, err.Error(), http.StatusBadGateway)
-		}
+		roundTripError(w, err)
 		return
 	}
 
@@ -210,6 +208,73 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// httpClientAddr returns the client address of request. If the request is
+// from a TrustedProxies address, returns the last X-Forwarded-For address
+// that is not a trusted proxy.
+func (srv *Server) httpClientAddr(r *http.Request) (addr string) {
+	addr = r.RemoteAddr
+	if len(srv.TrustedProxies) == 0 || !srv.TrustedProxies.ContainsAddr(addr) {
+		return
+	}
+	var forwarded []string
+	for _, v := range r.Header["X-Forwarded-For"] {
+		forwarded = append(forwarded, strings.Split(v, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			return
+		}
+		addr = ip.String()
+		if !srv.TrustedProxies.Contains(ip) {
+			return
+		}
+	}
+	return
+}
+
+// httpRoute returns the load balancer of request. The routes are matched in
+// order: the load balancer hosts (see HttpHosts.Match), the HttpDomain
+// subdomains and the DefaultHttpHost. The HttpDomain subdomains of services
+// not load balanced returns its listener.
+func (srv *Server) httpRoute(r *http.Request) (lb *LB, ln *ServiceListener) {
+	if lb = srv.HttpHosts.Match(r); lb != nil {
+		return
+	}
+	if ln = srv.domainRoute(requestHost(r)); ln != nil {
+		if ln.node != nil {
+			return ln.node.domainLB(), nil
+		}
+		return nil, ln
+	}
+	return srv.HttpHosts.Default(r), nil
+}
+
+// domainRoute returns the service listener of `<service>.<ap>.<HttpDomain>`
+// host. The HTTP clients are anonymous, so the service must be allowed by the
+// service ACL rules of AP, if any. See ServiceACL.Check.
+func (srv *Server) domainRoute(host string) *ServiceListener {
+	if srv.HttpDomain == "" {
+		return nil
+	}
+	sub := strings.TrimSuffix(host, "."+strings.ToLower(srv.HttpDomain))
+	if sub == host {
+		return nil
+	}
+	parts := strings.Split(sub, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil
+	}
+	ln, err := srv.register.GetUserListener("", parts[1], parts[0])
+	if err != nil {
+		if _, ok := err.(*ServiceAccessDeniedError); ok {
+			log.Println("HTTP:", "route of `"+host+"` denied by service ACL")
+		}
+		return nil
+	}
+	return ln
+}
+
 type ner struct {
 	r io.Reader
 }
@@ -224,33 +289,54 @@ func (t *ner) Read(p []byte) (n int, err error) {
 	return
 }
 
-// RoundTrip is http.RoundTriper implementation.
-func (srv *Server) RoundTrip(lb *LB, r *http.Request) (resp *http.Response, err error) {
+// upstreamRequest creates the upstream request of client request r: checks
+// the HTTP authentication, sets the forwarded headers and applies the route
+// rewrite and header rules. The vars is nil if route has no header rules.
+func (srv *Server) upstreamRequest(lb *LB, r *http.Request) (outr *http.Request, scheme string, vars *strings.Replacer, err error) {
 	var (
-		users   HttpUsers
-		enabled bool
+		users    HttpUsers
+		enabled  bool
+		authUser string
 	)
 	if users, enabled, err = srv.LoadBalancers.GetUsers(lb.Ap, lb.Service); err != nil {
 		return
 	}
 
-	outr := r.WithContext(r.Context())
+	outr = r.WithContext(r.Context())
 	if r.ContentLength == 0 {
 		outr.Body = nil // Issue 16036: nil Body for http.Transport retries
 	}
 	outr.Header = cloneHeader(r.Header)
+	removeHopHeaders(outr.Header)
+	// the client accepts trailers (required by gRPC), like
+	// httputil.ReverseProxy
+	if headerValuesContainsToken(r.Header["Te"], "trailers") {
+		outr.Header.Set("Te", "trailers")
+	}
+	outURL := *r.URL
+	outr.URL = &outURL
 
 	if enabled {
-		if user, password, ok := r.BasicAuth(); !ok || !users.Match(user, password) {
-			return nil, errUnauthorised
+		user, password, ok := r.BasicAuth()
+		if !ok || !matchHttpUser(lb, &users, user, password) {
+			return nil, "", nil, errUnauthorised
+		}
+
+		if users.NeedsRehash(user) {
+			oldHash, _ := users.Hash(user)
+			if ok, err := srv.LoadBalancers.HttpUserRehash(lb.Ap, lb.Service, user, oldHash, password); err != nil {
+				log.Printf("[%s{%s}] rehash password of HTTP user %q failed: %v", lb.Ap, lb.Service, user, err)
+			} else if ok {
+				log.Printf("[%s{%s}] password of HTTP user %q rehashed", lb.Ap, lb.Service, user)
+			}
 		}
 
 		outr.Header.Del("Authorization")
+		authUser = user
 	}
 
 	setXForwardedFor(outr.Header, r.RemoteAddr)
-	scheme := r.URL.Scheme
-	if scheme == "" {
+	if scheme = r.URL.Scheme; scheme == "" {
 		if r.TLS != nil {
 			scheme = "https"
 		} else {
@@ -263,50 +349,133 @@ func (srv *Server) RoundTrip(lb *LB, r *http.Request) (resp *http.Response, err
 		outr.Header.Set("X-Forwarded-Host", r.Host)
 		outr.Header.Set("X-Forwarded-Proto", scheme)
 	}
-	if r.Header.Get(httpu.DefaultUriPrefixHeader) == "" && lb.HttpPath != "" && lb.HttpPath != "/" {
+	if r.Header.Get(httpu.DefaultUriPrefixHeader) == "" && lb.HttpPath != "" && lb.HttpPath != "/" && !isPathRegexp(lb.HttpPath) {
 		outr.Header.Set(httpu.DefaultUriPrefixHeader, lb.HttpPath)
 	}
 	outr.RequestURI = ""
+	if lb.rewrite != nil {
+		lb.rewrite.Request(outr)
+	}
+	if len(lb.HttpHeaders) > 0 {
+		vars = srv.headerVars(lb, r, authUser, requestID(r), scheme)
+		lb.HttpHeaders.apply(outr.Header, false, vars)
+	}
+	return
+}
 
-	return srv.proxyHTTP(lb, outr)
+// upstreamResponse applies the route rewrite and header rules to upstream
+// response.
+func (srv *Server) upstreamResponse(lb *LB, r *http.Request, resp *http.Response, scheme string, vars *strings.Replacer) {
+	removeHopHeaders(resp.Header)
+	if lb.rewrite != nil {
+		lb.rewrite.Response(resp, r.Host, scheme)
+	}
+	if vars != nil {
+		lb.HttpHeaders.apply(resp.Header, true, vars)
+	}
 }
 
-func (s *Server) proxyHTTP(lb *LB, r *http.Request) (resp *http.Response, err error) {
-	var t http.RoundTripper
-	if r.Proto == "HTTP/2" {
-		var con net.Conn
-		con, err = lb.Node.NextDial(nil, r.RemoteAddr)
-		if err != nil {
-			return
+// endPointSelection returns the endpoint selection of request and the sticky
+// session endpoint ID.
+func endPointSelection(lb *LB, r *http.Request) (sel *EndPointSelection, stickyID string) {
+	sel = &EndPointSelection{}
+	if lb.StickySessions {
+		if cookie, err := r.Cookie(StickySessionCookie); err == nil {
+			stickyID = cookie.Value
 		}
+		sel.ID = stickyID
+	}
+	return
+}
 
-		defer con.Close()
-		t := &http2.Transport{
-			AllowHTTP: true,
+// setStickySession sets the sticky session cookie if the selected endpoint
+// changed.
+func setStickySession(lb *LB, resp *http.Response, sel *EndPointSelection, stickyID string) {
+	if lb.StickySessions && sel.ID != "" && sel.ID != stickyID {
+		resp.Header.Add("Set-Cookie", (&http.Cookie{
+			Name:     StickySessionCookie,
+			Value:    sel.ID,
+			Path:     lb.cookiePath(),
+			HttpOnly: true,
+		}).String())
+	}
+}
+
+// isReplayable reports whether the request can be sent again on another
+// matchHttpUser checks the password of HTTP user using the password cache of
+// load balancer node.
+func matchHttpUser(lb *LB, users *HttpUsers, user, password string) bool {
+	if lb.Node == nil {
+		return users.Match(user, password)
+	}
+	return lb.Node.passwords.Match(users, user, password)
+}
+
+// endpoint: the idempotent methods without body, like http.Transport retries.
+func isReplayable(r *http.Request) bool {
+	if r.Body != nil && r.Body != http.NoBody {
+		return false
+	}
+	switch r.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// RoundTrip is http.RoundTriper implementation.
+func (srv *Server) RoundTrip(lb *LB, r *http.Request) (resp *http.Response, err error) {
+	outr, scheme, vars, err := srv.upstreamRequest(lb, r)
+	if err != nil {
+		return
+	}
+
+	sel, stickyID := endPointSelection(lb, r)
+	outr = outr.WithContext(WithEndPointSelection(outr.Context(), sel))
+
+	maxRetries, _, _ := lb.Node.outlierConfig()
+	for attempt := 0; ; attempt++ {
+		if resp, err = srv.proxyHTTP(lb, outr); err == nil {
+			break
 		}
-		var ccon *http2.ClientConn
-		if ccon, err = t.NewClientConn(con); err != nil {
+		if sel.endPoint == nil {
 			return
 		}
-		t.ConnPool = &httpConnPool{ccon}
-	} else {
-		t = &http.Transport{
-			DialContext: func(ctx context.Context, network, addr string) (conn net.Conn, e error) {
-				return lb.Node.NextDial(ctx, r.RemoteAddr)
-			},
+		lb.Node.endPointFailed(sel.endPoint, err)
+		// only the idempotent requests without body that were not sent are
+		// retried
+		if !isEndPointDialError(err) || !isReplayable(outr) || attempt >= maxRetries {
+			return
 		}
+		log.Printf("[%s{%s}@%s] endpoint failed, retrying on another endpoint: %v", lb.Ap, lb.Service, r.RemoteAddr, err)
+		sel.retry()
 	}
-	httpClient := &http.Client{
-		Transport: t,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+
+	if sel.endPoint != nil {
+		if resp.StatusCode >= 500 {
+			lb.Node.endPointFailed(sel.endPoint, fmt.Errorf("HTTP status %q", resp.Status))
+		} else {
+			sel.endPoint.reportSuccess()
+		}
 	}
 
-	resp, err = httpClient.Do(r)
-	if err != nil {
+	srv.upstreamResponse(lb, r, resp, scheme, vars)
+	setStickySession(lb, resp, sel, stickyID)
+	return
+}
+
+// proxyHTTP sends the request to endpoint selected by balancer through the
+// node pooled transport.
+func (s *Server) proxyHTTP(lb *LB, r *http.Request) (resp *http.Response, err error) {
+	var sl *NodeServiceListener
+	if sl, err = lb.Node.NextEndPoint(r.Context(), s.httpClientAddr(r)); err != nil {
+		return
+	}
+	if resp, err = lb.Node.httpTransport().RoundTrip(sl, r); err != nil {
+		if isEndPointDialError(err) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("io error: %s", err)
 	}
-
 	return resp, nil
 }
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// endPointHostSuffix is the suffix of upstream request URL host. The host is
+// the endpoint ID, so that the transports pool the connections per endpoint.
+const endPointHostSuffix = ".endpoint"
+
+type httpTransportConfig struct {
+	// MaxIdleConns is the max idle connections per endpoint.
+	MaxIdleConns int
+	IdleTimeout  time.Duration
+	// Timeout is the max time to wait the response headers. If zero, waits
+	// forever.
+	Timeout time.Duration
+}
+
+func newHttpTransportConfig(lb *LoadBalancer) httpTransportConfig {
+	cfg := httpTransportConfig{
+		MaxIdleConns: lb.HttpMaxIdleConns,
+		IdleTimeout:  time.Duration(lb.HttpIdleTimeout) * time.Second,
+		Timeout:      time.Duration(lb.HttpTimeout) * time.Second,
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = http.DefaultMaxIdleConnsPerHost
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 90 * time.Second
+	}
+	return cfg
+}
+
+// httpTransport is the pooled HTTP transport of node. The HTTP/2 requests use
+// the HTTP/2 cleartext (h2c) transport, multiplexed over shared connections.
+type httpTransport struct {
+	node *Node
+	cfg  httpTransportConfig
+	h1   *http.Transport
+	h2   *http2.Transport
+}
+
+func newHttpTransport(n *Node, cfg httpTransportConfig) *httpTransport {
+	t := &httpTransport{node: n, cfg: cfg}
+	t.h1 = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return t.dial(ctx, addr)
+		},
+		MaxIdleConnsPerHost: cfg.MaxIdleConns,
+		IdleConnTimeout:     cfg.IdleTimeout,
+		DisableCompression:  true,
+	}
+	t.h2 = &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return t.dial(context.Background(), addr)
+		},
+	}
+	return t
+}
+
+// dial dials the endpoint of addr host.
+func (t *httpTransport) dial(ctx context.Context, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	id := strings.TrimSuffix(host, endPointHostSuffix)
+	sl := t.node.endPointByID(id)
+	if sl == nil {
+		return nil, fmt.Errorf("endpoint %q not found", id)
+	}
+	return sl.dial(ctx, "http-pool")
+}
+
+// RoundTrip sends the request to endpoint sl. The request is counted as
+// endpoint active connection until the response body is closed.
+func (t *httpTransport) RoundTrip(sl *NodeServiceListener, r *http.Request) (resp *http.Response, err error) {
+	ctx, cancel := context.WithCancel(r.Context())
+	outr := r.WithContext(ctx)
+	u := *r.URL
+	u.Scheme, u.Host = "http", sl.ID()+endPointHostSuffix
+	outr.URL = &u
+	if outr.Host == "" {
+		outr.Host = r.URL.Host
+	}
+
+	var rt http.RoundTripper = t.h1
+	if r.ProtoMajor == 2 {
+		rt = t.h2
+	}
+
+	var timer *time.Timer
+	if t.cfg.Timeout > 0 {
+		timer = time.AfterFunc(t.cfg.Timeout, cancel)
+	}
+
+	sl.acquire()
+	resp, err = rt.RoundTrip(outr)
+	if timer != nil && !timer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		err = fmt.Errorf("timeout after %s", t.cfg.Timeout)
+	}
+	if err != nil {
+		cancel()
+		sl.release()
+		return nil, err
+	}
+	resp.Body = &endPointBody{ReadCloser: resp.Body, done: func() {
+		cancel()
+		sl.release()
+	}}
+	return
+}
+
+// CloseIdleConnections closes the idle connections of all endpoints.
+func (t *httpTransport) CloseIdleConnections() {
+	t.h1.CloseIdleConnections()
+	t.h2.CloseIdleConnections()
+}
+
+// endPointBody calls done when the response body is closed.
+type endPointBody struct {
+	io.ReadCloser
+	done func()
+	once sync.Once
+}
+
+func (b *endPointBody) Close() error {
+	defer b.once.Do(b.done)
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+)
+
+// serveUpgrade proxies the upgrade request (WebSocket and others) to load
+// balancer endpoint. If endpoint switches protocol, the client and endpoint
+// connections are copied as raw streams.
+func (srv *Server) serveUpgrade(w http.ResponseWriter, r *http.Request, lb *LB) {
+	prfx := fmt.Sprintf("[%s{%s}@%s]", lb.Ap, lb.Service, r.RemoteAddr)
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "upgrade is not supported by "+r.Proto, http.StatusBadRequest)
+		return
+	}
+
+	upgrade := r.Header.Get("Upgrade")
+	outr, scheme, vars, err := srv.upstreamRequest(lb, r)
+	if err != nil {
+		roundTripError(w, err)
+		return
+	}
+	outr.Header.Set("Connection", "Upgrade")
+	outr.Header.Set("Upgrade", upgrade)
+
+	sel, stickyID := endPointSelection(lb, r)
+	outr = outr.WithContext(WithEndPointSelection(outr.Context(), sel))
+
+	var (
+		conn net.Conn
+		br   *bufio.Reader
+		resp *http.Response
+
+		maxRetries, _, _ = lb.Node.outlierConfig()
+	)
+	for attempt := 0; ; attempt++ {
+		if conn, br, resp, err = srv.dialUpgrade(lb, outr); err == nil {
+			break
+		}
+		if sel.endPoint != nil {
+			lb.Node.endPointFailed(sel.endPoint, err)
+		}
+		// the upgrade request is retried only if it was not sent
+		if sel.endPoint == nil || !isEndPointDialError(err) || attempt >= maxRetries {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		log.Println(prfx, "upgrade failed, retrying on another endpoint:", err.Error())
+		sel.retry()
+	}
+	defer conn.Close()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		if resp.StatusCode >= 500 {
+			lb.Node.endPointFailed(sel.endPoint, fmt.Errorf("HTTP status %q", resp.Status))
+		}
+		srv.upstreamResponse(lb, r, resp, scheme, vars)
+		setStickySession(lb, resp, sel, stickyID)
+		copyHeader(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	sel.endPoint.reportSuccess()
+
+	upgrade = resp.Header.Get("Upgrade")
+	srv.upstreamResponse(lb, r, resp, scheme, vars)
+	setStickySession(lb, resp, sel, stickyID)
+	resp.Header.Set("Connection", "Upgrade")
+	resp.Header.Set("Upgrade", upgrade)
+
+	clientConn, brw, err := hj.Hijack()
+	if err != nil {
+		log.Println(prfx, "hijack failed:", err.Error())
+		return
+	}
+	defer clientConn.Close()
+
+	fmt.Fprintf(brw, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(brw)
+	brw.WriteString("\r\n")
+	if err = brw.Flush(); err != nil {
+		log.Println(prfx, "write upgrade response failed:", err.Error())
+		return
+	}
+	log.Println(prfx, "upgraded to", upgrade)
+
+	// the buffered data of both readers is copied too
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(conn, brw.Reader)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(clientConn, br)
+		errc <- err
+	}()
+	<-errc
+	log.Println(prfx, upgrade, "closed")
+}
+
+// dialUpgrade dials the endpoint selected by balancer, writes the upgrade
+// request and reads the response.
+func (srv *Server) dialUpgrade(lb *LB, r *http.Request) (conn net.Conn, br *bufio.Reader, resp *http.Response, err error) {
+	if conn, err = lb.Node.NextDial(r.Context(), srv.httpClientAddr(r)); err != nil {
+		return
+	}
+	if err = r.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("write request failed: %v", err)
+	}
+	br = bufio.NewReader(conn)
+	if resp, err = http.ReadResponse(br, r); err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("read response failed: %v", err)
+	}
+	return
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moisespsena-go/task"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeChallengePath is the path prefix of ACME HTTP-01 challenges.
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+type ACMEConfig struct {
+	Email string
+	// DirectoryURL is the ACME directory URL. If blank, uses the Let's Encrypt
+	// production directory.
+	DirectoryURL string
+	// RootCAs are the trusted CAs of ACME directory server, like the local test
+	// servers (Pebble). If nil, uses the system CAs.
+	RootCAs *x509.CertPool
+	// CacheDir is the directory to store the ACME account and certificates. If
+	// blank, stores it on DB.
+	CacheDir string
+}
+
+type HttpsConfig struct {
+	Addr string
+	// CertFile and KeyFile are the default certificate, used when the client
+	// does not send the server name or none certificate matches it. Optional.
+	CertFile     string
+	KeyFile      string
+	DisableHttp2 bool
+	// ACME obtains certificates for the load balancers HTTP hosts. Optional.
+	ACME *ACMEConfig
+}
+
+// certSelector selects the HTTPS certificate by the client server name (SNI):
+// the manual certificate of host, then the ACME certificate, then the default
+// certificate.
+type certSelector struct {
+	srv         *Server
+	mu          sync.RWMutex
+	certs       map[string]*tls.Certificate
+	defaultCert *tls.Certificate
+	acme        *autocert.Manager
+	// acmeHTTP serves the ACME HTTP-01 challenges. The manager tries the
+	// HTTP-01 challenges only after its HTTP handler is created.
+	acmeHTTP http.Handler
+}
+
+func newCertSelector(srv *Server, cfg *HttpsConfig) (s *certSelector, err error) {
+	s = &certSelector{srv: srv}
+	if cfg.CertFile != "" {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile); err != nil {
+			return nil, fmt.Errorf("load default certificate failed: %v", err)
+		}
+		s.defaultCert = &cert
+	}
+	if cfg.ACME != nil {
+		s.acme = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Email:      cfg.ACME.Email,
+			HostPolicy: s.hostPolicy,
+			Client:     &acme.Client{DirectoryURL: cfg.ACME.DirectoryURL},
+		}
+		if cfg.ACME.CacheDir != "" {
+			s.acme.Cache = autocert.DirCache(cfg.ACME.CacheDir)
+		} else if srv.TLSCertificates != nil {
+			s.acme.Cache = srv.TLSCertificates.ACMECache()
+		}
+		if cfg.ACME.RootCAs != nil {
+			s.acme.Client.HTTPClient = &http.Client{Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: cfg.ACME.RootCAs},
+			}}
+		}
+		s.acmeHTTP = s.acme.HTTPHandler(nil)
+	}
+	return s, s.Reload()
+}
+
+// Reload loads the manual certificates from DB.
+func (s *certSelector) Reload() (err error) {
+	if s.srv.TLSCertificates == nil {
+		return
+	}
+	certs := map[string]*tls.Certificate{}
+	if err = s.srv.TLSCertificates.List(func(i int, c *TLSCertificate) error {
+		cert, err := parseKeyPair([]byte(c.Cert), []byte(c.Key))
+		if err != nil {
+			log.Println("HTTPS: certificate of `"+c.Host+"` ignored:", err.Error())
+			return nil
+		}
+		certs[c.Host] = cert
+		return nil
+	}); err != nil {
+		return fmt.Errorf("load TLS certificates failed: %v", err)
+	}
+	s.mu.Lock()
+	s.certs = certs
+	s.mu.Unlock()
+	return
+}
+
+// manual returns the valid manual certificate of host or the wildcard
+// certificate of its parent domain.
+func (s *certSelector) manual(host string) *tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := []string{host}
+	if pos := strings.IndexByte(host, '.'); pos > 0 {
+		names = append(names, "*"+host[pos:])
+	}
+	now := time.Now()
+	for _, name := range names {
+		if cert := s.certs[name]; cert != nil && now.Before(cert.Leaf.NotAfter) {
+			return cert
+		}
+	}
+	return nil
+}
+
+// hostPolicy allows the ACME certificates of load balancers HTTP hosts and
+// of `<service>.<ap>.<HttpDomain>` hosts.
+func (s *certSelector) hostPolicy(ctx context.Context, host string) (err error) {
+	host = strings.ToLower(host)
+	if s.srv.domainRoute(host) != nil {
+		return nil
+	}
+	if s.srv.LoadBalancers != nil {
+		var ok bool
+		if ok, err = s.srv.LoadBalancers.HasHttpHost(host); err != nil {
+			return
+		} else if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q is not a load balancer HTTP host", host)
+}
+
+func (s *certSelector) GetCertificate(hello *tls.ClientHelloInfo) (cert *tls.Certificate, err error) {
+	if s.acme != nil && len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto {
+		// TLS-ALPN-01 challenge
+		return s.acme.GetCertificate(hello)
+	}
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if host != "" {
+		if cert = s.manual(host); cert != nil {
+			return
+		}
+		if s.acme != nil {
+			if cert, err = s.acme.GetCertificate(hello); err == nil {
+				return
+			} else if s.defaultCert == nil {
+				return
+			}
+			log.Println("HTTPS: ACME certificate of `"+host+"` failed:", err.Error())
+		}
+	}
+	if s.defaultCert != nil {
+		return s.defaultCert, nil
+	}
+	return nil, fmt.Errorf("no certificate for host %q", host)
+}
+
+// serveACMEChallenge serves the ACME HTTP-01 challenges. Returns false if r is
+// not a challenge request.
+func (srv *Server) serveACMEChallenge(w http.ResponseWriter, r *http.Request) bool {
+	if srv.certs == nil || srv.certs.acme == nil || !strings.HasPrefix(r.URL.Path, acmeChallengePath) {
+		return false
+	}
+	srv.certs.acmeHTTP.ServeHTTP(w, r)
+	return true
+}
+
+func (srv *Server) setupHttps(appender task.Appender) (err error) {
+	if srv.certs, err = newCertSelector(srv, srv.Https); err != nil {
+		return
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: srv.certs.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+	}
+	srv.httpsServer = &http.Server{Handler: srv, TLSConfig: tlsConfig}
+	if srv.Https.DisableHttp2 {
+		tlsConfig.NextProtos = []string{"http/1.1", acme.ALPNProto}
+		srv.httpsServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
+	var ln net.Listener
+	if ln, err = net.Listen("tcp", srv.Https.Addr); err != nil {
+		return
+	}
+	log.Printf("starting https server on %v", ln.Addr())
+
+	_ = appender.AddTask(task.NewTask(func() (err error) {
+		go func() {
+			if err := srv.httpsServer.ServeTLS(&keepAliveListener{ln, srv}, "", ""); err != http.ErrServerClosed {
+				log.Println("ERROR: https server failed:", err.Error())
+			}
+		}()
+		return nil
+	}, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		srv.httpsServer.Shutdown(ctx)
+	}))
+	return nil
+}
+
+// keepAliveListener enables the TCP keep alive of accepted connections.
+type keepAliveListener struct {
+	net.Listener
+	srv *Server
+}
+
+func (l *keepAliveListener) Accept() (conn net.Conn, err error) {
+	if conn, err = l.Listener.Accept(); err != nil {
+		return
+	}
+	if err := l.srv.keepAlive(conn); err != nil {
+		log.Println("HTTPS: keep alive of <"+conn.RemoteAddr().String()+"> failed:", err.Error())
+	}
+	return
+}
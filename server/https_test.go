@@ -0,0 +1,432 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newTestServer returns the server with the load balancers and TLS
+// certificates stored on a temporary DB.
+func newTestServer(t *testing.T) (srv *Server, dir string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "xssh-https-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := NewDB(filepath.Join(dir, "xssh.db")).Init()
+	srv = &Server{
+		LoadBalancers:   NewLoadBalancers(db),
+		TLSCertificates: NewTLSCertificates(db),
+	}
+	return srv, dir, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// newTestCert returns the PEM encoded certificate and key of hosts signed by
+// parent (self signed if nil). The DER certificate is returned too.
+func newTestCert(t *testing.T, parent *tls.Certificate, pub interface{}, notAfter time.Time, hosts ...string) (certPEM, keyPEM, der []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: strings.Join(hosts, ",")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		DNSNames:              hosts,
+	}
+	var (
+		issuer             = tpl
+		signer interface{} = key
+	)
+	if parent != nil {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	if pub == nil {
+		pub = &key.PublicKey
+	}
+	if der, err = x509.CreateCertificate(rand.Reader, tpl, issuer, pub, signer); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), der
+}
+
+func TestTLSCertificates(t *testing.T) {
+	srv, _, cleanup := newTestServer(t)
+	defer cleanup()
+	certs := srv.TLSCertificates
+
+	notAfter := time.Now().Add(24 * time.Hour)
+	wwwCert, wwwKey, _ := newTestCert(t, nil, nil, notAfter, "www.example.com")
+	wildcardCert, wildcardKey, _ := newTestCert(t, nil, nil, notAfter, "*.example.org")
+
+	if err := certs.Add("WWW.Example.com", wwwCert, wwwKey); err != nil {
+		t.Fatalf("Add www.example.com: %v", err)
+	}
+	if err := certs.Add("*.example.org", wildcardCert, wildcardKey); err != nil {
+		t.Fatalf("Add *.example.org: %v", err)
+	}
+	if err := certs.Add("other.example.com", wwwCert, wwwKey); err == nil {
+		t.Error("Add other.example.com with the certificate of www.example.com: expected error")
+	}
+	if err := certs.Add("www.example.com", wwwCert, wildcardKey); err == nil {
+		t.Error("Add www.example.com with other key: expected error")
+	}
+
+	var hosts []string
+	if err := certs.List(func(i int, c *TLSCertificate) error {
+		hosts = append(hosts, c.Host)
+		if c.NotAfter.Unix() != notAfter.Unix() {
+			t.Errorf("%s: NotAfter = %v; want %v", c.Host, c.NotAfter, notAfter)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(hosts, " "), "*.example.org www.example.com"; got != want {
+		t.Errorf("List hosts = %q; want %q", got, want)
+	}
+
+	if removed, err := certs.Remove("WWW.example.com", "none.example.com"); err != nil {
+		t.Fatal(err)
+	} else if removed != 1 {
+		t.Errorf("Remove = %d; want 1", removed)
+	}
+	hosts = nil
+	certs.List(func(i int, c *TLSCertificate) error {
+		hosts = append(hosts, c.Host)
+		return nil
+	})
+	if got, want := strings.Join(hosts, " "), "*.example.org"; got != want {
+		t.Errorf("List hosts after Remove = %q; want %q", got, want)
+	}
+
+	ctx, cache := context.Background(), certs.ACMECache()
+	if _, err := cache.Get(ctx, "example.org"); err != autocert.ErrCacheMiss {
+		t.Errorf("ACME cache Get of missing key = %v; want %v", err, autocert.ErrCacheMiss)
+	}
+	if err := cache.Put(ctx, "example.org", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := cache.Get(ctx, "example.org"); err != nil || string(data) != "data" {
+		t.Errorf("ACME cache Get = %q, %v; want %q", data, err, "data")
+	}
+	if err := cache.Delete(ctx, "example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(ctx, "example.org"); err != autocert.ErrCacheMiss {
+		t.Errorf("ACME cache Get of deleted key = %v; want %v", err, autocert.ErrCacheMiss)
+	}
+}
+
+func TestCertSelectorGetCertificate(t *testing.T) {
+	srv, dir, cleanup := newTestServer(t)
+	defer cleanup()
+
+	var (
+		notAfter                      = time.Now().Add(24 * time.Hour)
+		wwwCert, wwwKey, wwwDER       = newTestCert(t, nil, nil, notAfter, "www.example.com")
+		wildcardCert, wildcardKey, wc = newTestCert(t, nil, nil, notAfter, "*.example.org")
+		oldCert, oldKey, _            = newTestCert(t, nil, nil, time.Now().Add(-time.Minute), "old.example.com")
+		defaultCert, defaultKey, dDER = newTestCert(t, nil, nil, notAfter, "default.example.com")
+		certFile                      = filepath.Join(dir, "cert.pem")
+		keyFile                       = filepath.Join(dir, "key.pem")
+	)
+	for host, pair := range map[string][2][]byte{
+		"www.example.com": {wwwCert, wwwKey},
+		"*.example.org":   {wildcardCert, wildcardKey},
+		"old.example.com": {oldCert, oldKey},
+	} {
+		if err := srv.TLSCertificates.Add(host, pair[0], pair[1]); err != nil {
+			t.Fatalf("Add %s: %v", host, err)
+		}
+	}
+	ioutil.WriteFile(certFile, defaultCert, 0600)
+	ioutil.WriteFile(keyFile, defaultKey, 0600)
+
+	s, err := newCertSelector(srv, &HttpsConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		serverName string
+		want       []byte
+	}{
+		{"www.example.com", wwwDER},
+		{"WWW.Example.COM.", wwwDER},
+		{"a.example.org", wc},
+		{"example.org", dDER},
+		{"a.b.example.org", dDER},
+		{"old.example.com", dDER},
+		{"", dDER},
+	} {
+		cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: tc.serverName})
+		if err != nil {
+			t.Errorf("GetCertificate(%q): %v", tc.serverName, err)
+			continue
+		}
+		if !bytes.Equal(cert.Certificate[0], tc.want) {
+			t.Errorf("GetCertificate(%q): unexpected certificate", tc.serverName)
+		}
+	}
+
+	newCert, newKey, newDER := newTestCert(t, nil, nil, notAfter, "new.example.com")
+	if err = srv.TLSCertificates.Add("new.example.com", newCert, newKey); err != nil {
+		t.Fatal(err)
+	}
+	if cert, _ := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "new.example.com"}); bytes.Equal(cert.Certificate[0], newDER) {
+		t.Error("GetCertificate(new.example.com) before Reload: expected the default certificate")
+	}
+	if err = s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cert, _ := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "new.example.com"}); !bytes.Equal(cert.Certificate[0], newDER) {
+		t.Error("GetCertificate(new.example.com) after Reload: unexpected certificate")
+	}
+
+	if s, err = newCertSelector(srv, &HttpsConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.GetCertificate(&tls.ClientHelloInfo{ServerName: "none.example.com"}); err == nil {
+		t.Error("GetCertificate(none.example.com) without default certificate: expected error")
+	}
+}
+
+func TestCertSelectorHostPolicy(t *testing.T) {
+	srv, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	host := "App.Example.com"
+	if err := srv.LoadBalancers.Add("ap1", "web", 2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.LoadBalancers.SetHttpHost("ap1", "web", &host); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.LoadBalancers.Add("ap1", "ssh", 2, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &certSelector{srv: srv}
+	ctx := context.Background()
+	for _, host := range []string{"app.example.com", "APP.example.com"} {
+		if err := s.hostPolicy(ctx, host); err != nil {
+			t.Errorf("hostPolicy(%q): %v", host, err)
+		}
+	}
+	for _, host := range []string{"example.com", "www.example.com", ""} {
+		if err := s.hostPolicy(ctx, host); err == nil {
+			t.Errorf("hostPolicy(%q): expected error", host)
+		}
+	}
+
+	s.srv = &Server{}
+	if err := s.hostPolicy(ctx, "app.example.com"); err == nil {
+		t.Error("hostPolicy without load balancers: expected error")
+	}
+}
+
+// acmeTestCA is a local ACME (draft-02, the protocol of the vendored acme
+// client) CA that validates the HTTP-01 challenges through the
+// serveACMEChallenge of srv.
+type acmeTestCA struct {
+	*httptest.Server
+	t      *testing.T
+	srv    *Server
+	cert   *tls.Certificate
+	token  string
+	issued []string
+}
+
+func newACMETestCA(t *testing.T, srv *Server) *acmeTestCA {
+	certPEM, keyPEM, _ := newTestCert(t, nil, nil, time.Now().Add(24*time.Hour), "ca.test")
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &acmeTestCA{t: t, srv: srv, cert: cert, token: "token-http-01"}
+	ca.Server = httptest.NewTLSServer(http.HandlerFunc(ca.serveHTTP))
+	return ca
+}
+
+// payload decodes the JWS payload of request.
+func (ca *acmeTestCA) payload(r *http.Request, v interface{}) error {
+	var req struct{ Payload string }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(req.Payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
+
+func (ca *acmeTestCA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", "nonce")
+	if r.Method == http.MethodHead {
+		return
+	}
+	switch r.URL.Path {
+	case "/":
+		json.NewEncoder(w).Encode(map[string]string{
+			"new-reg":   ca.URL + "/new-reg",
+			"new-authz": ca.URL + "/new-authz",
+			"new-cert":  ca.URL + "/new-cert",
+		})
+	case "/new-reg":
+		w.Header().Set("Location", ca.URL+"/reg/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	case "/new-authz":
+		w.Header().Set("Location", ca.URL+"/authz/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "pending",
+			"challenges": []map[string]string{
+				{"uri": ca.URL + "/challenge/http-01", "type": "http-01", "token": ca.token},
+			},
+		})
+	case "/challenge/http-01":
+		// validates the challenge before accept it
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://app.example.com"+acmeChallengePath+ca.token, nil)
+		if !ca.srv.serveACMEChallenge(rec, req) {
+			ca.t.Error("serveACMEChallenge: challenge request not served")
+		} else if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), ca.token+".") {
+			ca.t.Errorf("serveACMEChallenge: response = %d %q; want %d %q", rec.Code, rec.Body.String(),
+				http.StatusOK, ca.token+".<thumbprint>")
+		}
+		w.Write([]byte(`{"status": "valid"}`))
+	case "/authz/1":
+		w.Write([]byte(`{"status": "valid"}`))
+	case "/new-cert":
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		if err := ca.payload(r, &req); err != nil {
+			ca.t.Errorf("new-cert: %v", err)
+		}
+		b, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(b)
+		if err != nil {
+			ca.t.Errorf("new-cert: CSR: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ca.issued = append(ca.issued, csr.Subject.CommonName)
+		_, _, der := newTestCert(ca.t, ca.cert, csr.PublicKey, time.Now().Add(24*time.Hour), csr.Subject.CommonName)
+		w.Header().Set("Link", "<"+ca.URL+"/ca-cert>; rel=up")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(der)
+	case "/ca-cert":
+		w.Write(ca.cert.Certificate[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestServeACMEChallenge(t *testing.T) {
+	srv, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	challenge := httptest.NewRequest(http.MethodGet, "http://app.example.com"+acmeChallengePath+"token", nil)
+	if srv.serveACMEChallenge(httptest.NewRecorder(), challenge) {
+		t.Error("serveACMEChallenge without HTTPS: challenge served")
+	}
+
+	host := "app.example.com"
+	if err := srv.LoadBalancers.Add("ap1", "web", 2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.LoadBalancers.SetHttpHost("ap1", "web", &host); err != nil {
+		t.Fatal(err)
+	}
+
+	ca := newACMETestCA(t, srv)
+	defer ca.Close()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.Certificate())
+
+	var err error
+	if srv.certs, err = newCertSelector(srv, &HttpsConfig{ACME: &ACMEConfig{
+		Email:        "admin@example.com",
+		DirectoryURL: ca.URL,
+		RootCAs:      rootCAs,
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	hello := &tls.ClientHelloInfo{
+		ServerName:   host,
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}
+	cert, err := srv.certs.GetCertificate(hello)
+	if err != nil {
+		t.Fatalf("GetCertificate(%q): %v", host, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = leaf.VerifyHostname(host); err != nil {
+		t.Errorf("GetCertificate(%q): %v", host, err)
+	}
+	if err = leaf.CheckSignatureFrom(ca.cert.Leaf); err != nil {
+		t.Errorf("GetCertificate(%q): not issued by ACME CA: %v", host, err)
+	}
+	if _, err = srv.TLSCertificates.ACMECache().Get(context.Background(), host); err != nil {
+		t.Errorf("ACME certificate of %q is not cached on DB: %v", host, err)
+	}
+
+	other := httptest.NewRequest(http.MethodGet, "http://app.example.com/index.html", nil)
+	if srv.serveACMEChallenge(httptest.NewRecorder(), other) {
+		t.Error("serveACMEChallenge: not challenge request served")
+	}
+	rec := httptest.NewRecorder()
+	if !srv.serveACMEChallenge(rec, challenge) {
+		t.Error("serveACMEChallenge: challenge request not served")
+	} else if rec.Code != http.StatusNotFound {
+		t.Errorf("serveACMEChallenge of unknown token: status = %d; want %d", rec.Code, http.StatusNotFound)
+	}
+
+	hello.ServerName = "denied.example.com"
+	if _, err = srv.certs.GetCertificate(hello); err == nil {
+		t.Error("GetCertificate(denied.example.com): expected error")
+	}
+	if got := strings.Join(ca.issued, " "); got != host {
+		t.Errorf("ACME issued certificates = %q; want %q", got, host)
+	}
+}
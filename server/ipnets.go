@@ -0,0 +1,98 @@
+package server
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// IPNets is a list of IP networks. Stored as comma separated CIDRs.
+type IPNets []*net.IPNet
+
+// ParseIPNets parses the CIDRs (`10.0.0.0/8`) or IP addresses (`10.1.2.3`).
+func ParseIPNets(values ...string) (nets IPNets, err error) {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("bad IP address %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		var ipNet *net.IPNet
+		if _, ipNet, err = net.ParseCIDR(v); err != nil {
+			return nil, fmt.Errorf("bad CIDR %q: %v", v, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return
+}
+
+// Contains reports whether any network contains the ip.
+func (nets IPNets) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsAddr reports whether any network contains the IP of addr
+// (`IP:PORT` or `IP`).
+func (nets IPNets) ContainsAddr(addr string) bool {
+	return nets.Contains(net.ParseIP(clientIP(addr)))
+}
+
+func (nets IPNets) Strings() []string {
+	values := make([]string, len(nets))
+	for i, n := range nets {
+		values[i] = n.String()
+	}
+	return values
+}
+
+func (nets IPNets) String() string {
+	return strings.Join(nets.Strings(), ",")
+}
+
+func (nets IPNets) MarshalJSON() ([]byte, error) {
+	return json.Marshal(nets.Strings())
+}
+
+func (nets *IPNets) UnmarshalJSON(data []byte) (err error) {
+	var values []string
+	if err = json.Unmarshal(data, &values); err != nil {
+		return
+	}
+	*nets, err = ParseIPNets(values...)
+	return
+}
+
+func (nets *IPNets) Value() (driver.Value, error) {
+	return nets.String(), nil
+}
+
+func (nets *IPNets) Scan(src interface{}) (err error) {
+	*nets = nil
+	switch t := src.(type) {
+	case []byte:
+		*nets, err = ParseIPNets(strings.Split(string(t), ",")...)
+	case string:
+		*nets, err = ParseIPNets(strings.Split(t, ",")...)
+	}
+	return
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// OpenSSH key revocation list (KRL) format, generated by `ssh-keygen -k`. See
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.krl
+const (
+	krlMagic         = "SSHKRL\n\x00"
+	krlFormatVersion = 1
+
+	krlSectionCertificates      = 1
+	krlSectionExplicitKey       = 2
+	krlSectionFingerprintSHA1   = 3
+	krlSectionSignature         = 4
+	krlSectionFingerprintSHA256 = 5
+
+	krlCertSerialList   = 0x20
+	krlCertSerialRange  = 0x21
+	krlCertSerialBitmap = 0x22
+	krlCertKeyID        = 0x23
+)
+
+// krlCerts is the revoked certificates of CA key.
+type krlCerts struct {
+	serials [][2]uint64
+	ids     map[string]bool
+}
+
+func (c *krlCerts) revoked(cert *gossh.Certificate) bool {
+	if c == nil {
+		return false
+	}
+	if c.ids[cert.KeyId] {
+		return true
+	}
+	for _, s := range c.serials {
+		if cert.Serial >= s[0] && cert.Serial <= s[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// krl is the parsed key revocation list.
+type krl struct {
+	keys   map[string]bool
+	sha1   map[string]bool
+	sha256 map[string]bool
+	// certs is the revoked certificates by CA key blob. The blank CA matches
+	// certificates of any CA.
+	certs map[string]*krlCerts
+}
+
+// keyRevoked reports whether the plain key is revoked.
+func (k *krl) keyRevoked(key gossh.PublicKey) bool {
+	blob := key.Marshal()
+	if k.keys[string(blob)] {
+		return true
+	}
+	h1 := sha1.Sum(blob)
+	h256 := sha256.Sum256(blob)
+	return k.sha1[string(h1[:])] || k.sha256[string(h256[:])]
+}
+
+// certRevoked reports whether the certificate, its key or its CA key is
+// revoked.
+func (k *krl) certRevoked(cert *gossh.Certificate) bool {
+	return k.keyRevoked(cert.Key) ||
+		k.keyRevoked(cert.SignatureKey) ||
+		k.certs[string(cert.SignatureKey.Marshal())].revoked(cert) ||
+		k.certs[""].revoked(cert)
+}
+
+// krlReader reads the SSH wire format values.
+type krlReader struct {
+	data []byte
+	err  error
+}
+
+func (r *krlReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = errors.New("unexpected end of data")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *krlReader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *krlReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *krlReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *krlReader) string() []byte {
+	return r.next(int(r.uint32()))
+}
+
+// parseKRL parses the binary OpenSSH key revocation list.
+func parseKRL(data []byte) (k *krl, err error) {
+	if !bytes.HasPrefix(data, []byte(krlMagic)) {
+		return nil, errors.New("not an OpenSSH KRL")
+	}
+	r := &krlReader{data: data[len(krlMagic):]}
+	if v := r.uint32(); r.err == nil && v != krlFormatVersion {
+		return nil, fmt.Errorf("unsupported KRL format version %d", v)
+	}
+	// krl_version, generated_date, flags, reserved and comment
+	r.uint64()
+	r.uint64()
+	r.uint64()
+	r.string()
+	r.string()
+
+	k = &krl{
+		keys:   map[string]bool{},
+		sha1:   map[string]bool{},
+		sha256: map[string]bool{},
+		certs:  map[string]*krlCerts{},
+	}
+
+	for r.err == nil && len(r.data) > 0 {
+		typ, section := r.byte(), &krlReader{data: r.string()}
+		if r.err != nil {
+			break
+		}
+		switch typ {
+		case krlSectionCertificates:
+			err = k.parseCerts(section)
+		case krlSectionExplicitKey:
+			for section.err == nil && len(section.data) > 0 {
+				k.keys[string(section.string())] = true
+			}
+		case krlSectionFingerprintSHA1:
+			for section.err == nil && len(section.data) > 0 {
+				k.sha1[string(section.string())] = true
+			}
+		case krlSectionFingerprintSHA256:
+			for section.err == nil && len(section.data) > 0 {
+				k.sha256[string(section.string())] = true
+			}
+		case krlSectionSignature:
+			// the signatures are verified by ssh-keygen, not by sshd
+			return k, nil
+		default:
+			return nil, fmt.Errorf("unsupported KRL section type %d", typ)
+		}
+		if err == nil {
+			err = section.err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad KRL section %d: %v", typ, err)
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("bad KRL: %v", r.err)
+	}
+	return
+}
+
+func (k *krl) parseCerts(r *krlReader) error {
+	caKey := r.string()
+	r.string() // reserved
+	if r.err != nil {
+		return r.err
+	}
+	c := k.certs[string(caKey)]
+	if c == nil {
+		c = &krlCerts{ids: map[string]bool{}}
+		k.certs[string(caKey)] = c
+	}
+	for r.err == nil && len(r.data) > 0 {
+		typ, sub := r.byte(), &krlReader{data: r.string()}
+		if r.err != nil {
+			break
+		}
+		switch typ {
+		case krlCertSerialList:
+			for sub.err == nil && len(sub.data) > 0 {
+				s := sub.uint64()
+				c.serials = append(c.serials, [2]uint64{s, s})
+			}
+		case krlCertSerialRange:
+			min, max := sub.uint64(), sub.uint64()
+			c.serials = append(c.serials, [2]uint64{min, max})
+		case krlCertSerialBitmap:
+			offset, bitmap := sub.uint64(), new(big.Int).SetBytes(sub.string())
+			for i := 0; i < bitmap.BitLen(); i++ {
+				if bitmap.Bit(i) == 1 {
+					c.serials = append(c.serials, [2]uint64{offset + uint64(i), offset + uint64(i)})
+				}
+			}
+		case krlCertKeyID:
+			for sub.err == nil && len(sub.data) > 0 {
+				c.ids[string(sub.string())] = true
+			}
+		default:
+			return fmt.Errorf("unsupported certificates subsection type %d", typ)
+		}
+		if sub.err != nil {
+			return sub.err
+		}
+	}
+	return r.err
+}
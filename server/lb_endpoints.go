@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// EndPointStatus is the state of load balancer endpoint saved by running
+// server.
+type EndPointStatus struct {
+	Ap          string     `json:"ap"`
+	Service     string     `json:"service"`
+	Addr        string     `json:"addr"`
+	Weight      int        `json:"weight"`
+	Healthy     bool       `json:"healthy"`
+	LastCheckAt *time.Time `json:"last_check_at"`
+	LastError   string     `json:"last_error"`
+	// EjectedUntil is the end time of passive outlier ejection.
+	EjectedUntil *time.Time `json:"ejected_until"`
+	// Draining is true when the AP is disconnecting: the endpoint receives no
+	// new connections.
+	Draining  bool      `json:"draining"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Ejected reports whether the endpoint is ejected by passive outlier
+// detection.
+func (s EndPointStatus) Ejected() bool {
+	return s.EjectedUntil != nil && time.Now().Before(*s.EjectedUntil)
+}
+
+func (s EndPointStatus) String() string {
+	str := s.Addr + " weight=" + strconv.Itoa(s.Weight)
+	if s.LastCheckAt != nil {
+		str += " checked=" + s.LastCheckAt.Format(time.RFC3339)
+	}
+	if s.Draining {
+		str += " [DRAINING]"
+	} else if !s.Healthy {
+		str += " [UNHEALTHY: " + s.LastError + "]"
+	} else if s.Ejected() {
+		str += " [EJECTED until " + s.EjectedUntil.Format(time.RFC3339) + ": " + s.LastError + "]"
+	} else {
+		str += " [HEALTHY]"
+	}
+	return str
+}
+
+func (sl *NodeServiceListener) endPointStatus() *EndPointStatus {
+	h := sl.Health()
+	st := &EndPointStatus{
+		Addr:      sl.key,
+		Weight:    sl.weight(),
+		Healthy:   !h.Unhealthy,
+		LastError: h.LastError,
+		Draining:  sl.Draining(),
+	}
+	if !h.LastCheckAt.IsZero() {
+		st.LastCheckAt = &h.LastCheckAt
+	}
+	if !h.EjectedUntil.IsZero() {
+		st.EjectedUntil = &h.EjectedUntil
+	}
+	return st
+}
+
+// SaveEndPoint saves the endpoint state.
+func (s *LoadBalancers) SaveEndPoint(ap, service string, st *EndPointStatus) (err error) {
+	_, err = s.DB.Exec("INSERT OR REPLACE INTO lb_endpoints (ap, service, addr, weight, healthy, last_check_at, "+
+		"last_error, ejected_until, draining, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		ap, service, st.Addr, st.Weight, st.Healthy, st.LastCheckAt, st.LastError, st.EjectedUntil, st.Draining, time.Now())
+	if err != nil {
+		return fmt.Errorf("DB Exec failed: %v", err)
+	}
+	return nil
+}
+
+// RemoveEndPoint removes the endpoint state.
+func (s *LoadBalancers) RemoveEndPoint(ap, service, addr string) (err error) {
+	if _, err = s.DB.Exec("DELETE FROM lb_endpoints WHERE ap = ? AND service = ? AND addr = ?", ap, service, addr); err != nil {
+		return fmt.Errorf("DB Exec failed: %v", err)
+	}
+	return nil
+}
+
+// ClearEndPoints removes the endpoints state of all load balancers. Used on
+// server start.
+func (s *LoadBalancers) ClearEndPoints() (err error) {
+	if _, err = s.DB.Exec("DELETE FROM lb_endpoints"); err != nil {
+		return fmt.Errorf("DB Exec failed: %v", err)
+	}
+	return nil
+}
+
+// EndPoints lists the endpoints state. If ap is blank, lists all.
+func (s *LoadBalancers) EndPoints(ap, service string, cb func(i int, st *EndPointStatus) error) (err error) {
+	sqls := "SELECT ap, service, addr, weight, healthy, last_check_at, last_error, ejected_until, draining, updated_at " +
+		"FROM lb_endpoints"
+	var args []interface{}
+	if ap != "" {
+		sqls += " WHERE ap = ? AND service = ?"
+		args = append(args, ap, service)
+	}
+
+	rows, err := s.DB.Query(sqls+" ORDER BY ap, service, addr", args...)
+	if err != nil {
+		return fmt.Errorf("DB Query failed: %v", err)
+	}
+	defer rows.Close()
+
+	for i := 1; rows.Next(); i++ {
+		var st EndPointStatus
+		if err = rows.Scan(&st.Ap, &st.Service, &st.Addr, &st.Weight, &st.Healthy, &st.LastCheckAt, &st.LastError,
+			&st.EjectedUntil, &st.Draining, &st.UpdatedAt); err != nil {
+			return fmt.Errorf("Scan endpoint %d failed: %v", i, err)
+		}
+		if err = cb(i, &st); err != nil {
+			if err == ErrStopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -1,22 +1,153 @@
 package server
 
 import (
+	"bufio"
 	"database/sql"
 	"fmt"
+	"io"
+	"net"
+	"regexp"
 	"strings"
 
 	"github.com/go-errors/errors"
 )
 
 type LoadBalancer struct {
-	Ap, Service string
-	PublicAddr  *string
-	HttpHost    *string
-	HttpPath    string
-	MaxCount    int
-	UnixSocket  bool
+	Ap              string  `json:"ap"`
+	Service         string  `json:"service"`
+	PublicAddr      *string `json:"public_addr"`
+	HttpHost        *string `json:"http_host"`
+	HttpPath        string  `json:"http_path"`
+	MaxCount        int     `json:"max_count"`
+	UnixSocket      bool    `json:"unix_socket"`
+	HttpAuthEnabled bool    `json:"http_auth_enabled"`
+	Balancer        string  `json:"balancer"`
+	StickySessions  bool    `json:"sticky_sessions"`
+
+	// HealthCheck is the active health check type: blank (disabled),
+	// HealthCheckTCP or HealthCheckHTTP.
+	HealthCheck         string `json:"health_check"`
+	HealthCheckPath     string `json:"health_check_path"`
+	HealthCheckInterval int    `json:"health_check_interval"`
+	HealthCheckTimeout  int    `json:"health_check_timeout"`
+	HealthyThreshold    int    `json:"healthy_threshold"`
+	UnhealthyThreshold  int    `json:"unhealthy_threshold"`
+
+	// MaxRetries is the max retries on another endpoint when the endpoint
+	// dial fails. If zero, disables the retries.
+	MaxRetries int `json:"max_retries"`
+	// OutlierErrors is the consecutive traffic errors (connection failures and
+	// HTTP 5xx) to eject an endpoint for OutlierEjectionTime seconds. If zero,
+	// disables the passive outlier detection.
+	OutlierErrors       int `json:"outlier_errors"`
+	OutlierEjectionTime int `json:"outlier_ejection_time"`
+
+	// StripPrefix removes the HTTP path prefix from the upstream request path.
+	// The Location and Set-Cookie paths of response are prefixed back.
+	StripPrefix bool `json:"strip_prefix"`
+	// PathRewrite is the regular expression replaced by PathRewriteReplacement
+	// in upstream request path, after StripPrefix.
+	PathRewrite            string `json:"path_rewrite"`
+	PathRewriteReplacement string `json:"path_rewrite_replacement"`
+	// UpstreamHost is the upstream request Host header. If blank, uses the
+	// client request host.
+	UpstreamHost string `json:"upstream_host"`
+	// HttpHeaders is the request and response headers changes.
+	HttpHeaders HeaderRules `json:"http_headers"`
+
+	// HttpMaxIdleConns is the max idle pooled HTTP connections per endpoint.
+	HttpMaxIdleConns int `json:"http_max_idle_conns"`
+	// HttpIdleTimeout is the idle pooled HTTP connection lifetime in seconds.
+	HttpIdleTimeout int `json:"http_idle_timeout"`
+	// HttpTimeout is the max time in seconds to wait the response headers. If
+	// zero, waits forever.
+	HttpTimeout int `json:"http_timeout"`
+	// TLSSNIHost routes the TLS connections of passthrough listener with this
+	// server name (SNI) to the load balancer, without decrypt them. Accepts
+	// wildcard hosts (`*.example.com`).
+	TLSSNIHost string `json:"tls_sni_host"`
+	// PublicUDPAddr is the public UDP addr. The datagrams of each client are
+	// relayed to an endpoint as a flow. The AP service must be UDP.
+	PublicUDPAddr string `json:"public_udp_addr"`
+	// Allow is the source networks accepted by public TCP listener and HTTP
+	// route. If empty, all sources are accepted, except the Deny networks.
+	Allow IPNets `json:"allow"`
+	// Deny is the source networks rejected by public TCP listener and HTTP
+	// route. It has precedence over Allow.
+	Deny IPNets `json:"deny"`
+
+	*Nodes `json:"-"`
+}
 
-	*Nodes
+// sourceAllowed reports whether the client ip is not denied and, if Allow is
+// not empty, is allowed.
+func (lb *LoadBalancer) sourceAllowed(ip net.IP) bool {
+	if lb.Deny.Contains(ip) {
+		return false
+	}
+	return len(lb.Allow) == 0 || lb.Allow.Contains(ip)
+}
+
+func (lb *LoadBalancer) publicAddr() string {
+	if lb.PublicAddr == nil {
+		return ""
+	}
+	return *lb.PublicAddr
+}
+
+func (lb *LoadBalancer) httpHost() string {
+	if lb.HttpHost == nil {
+		return ""
+	}
+	return strings.ToLower(*lb.HttpHost)
+}
+
+func (lb *LoadBalancer) tlsSNIHost() string {
+	return strings.ToLower(lb.TLSSNIHost)
+}
+
+// cookiePath returns the path of HTTP cookies set by load balancer.
+func (lb *LoadBalancer) cookiePath() string {
+	if lb.HttpPath == "" || isPathRegexp(lb.HttpPath) {
+		return "/"
+	}
+	return lb.HttpPath
+}
+
+// sameConfig reports whether the lb and other load balancers have the same
+// configuration values.
+func (lb *LoadBalancer) sameConfig(other *LoadBalancer) bool {
+	return lb.Ap == other.Ap &&
+		lb.Service == other.Service &&
+		lb.MaxCount == other.MaxCount &&
+		lb.UnixSocket == other.UnixSocket &&
+		lb.HttpPath == other.HttpPath &&
+		lb.HttpAuthEnabled == other.HttpAuthEnabled &&
+		lb.Balancer == other.Balancer &&
+		lb.StickySessions == other.StickySessions &&
+		lb.HealthCheck == other.HealthCheck &&
+		lb.HealthCheckPath == other.HealthCheckPath &&
+		lb.HealthCheckInterval == other.HealthCheckInterval &&
+		lb.HealthCheckTimeout == other.HealthCheckTimeout &&
+		lb.HealthyThreshold == other.HealthyThreshold &&
+		lb.UnhealthyThreshold == other.UnhealthyThreshold &&
+		lb.MaxRetries == other.MaxRetries &&
+		lb.OutlierErrors == other.OutlierErrors &&
+		lb.OutlierEjectionTime == other.OutlierEjectionTime &&
+		lb.StripPrefix == other.StripPrefix &&
+		lb.PathRewrite == other.PathRewrite &&
+		lb.PathRewriteReplacement == other.PathRewriteReplacement &&
+		lb.UpstreamHost == other.UpstreamHost &&
+		lb.HttpHeaders.equal(other.HttpHeaders) &&
+		lb.HttpMaxIdleConns == other.HttpMaxIdleConns &&
+		lb.HttpIdleTimeout == other.HttpIdleTimeout &&
+		lb.HttpTimeout == other.HttpTimeout &&
+		lb.tlsSNIHost() == other.tlsSNIHost() &&
+		lb.PublicUDPAddr == other.PublicUDPAddr &&
+		lb.Allow.String() == other.Allow.String() &&
+		lb.Deny.String() == other.Deny.String() &&
+		lb.publicAddr() == other.publicAddr() &&
+		lb.httpHost() == other.httpHost()
 }
 
 type LoadBalancerFilter struct {
@@ -34,7 +165,7 @@ func NewLoadBalancers(DB *DB) *LoadBalancers {
 
 func (s *LoadBalancers) Add(ap, service string, maxCount int, publicAddr string) (err error) {
 	_, err = s.DB.Exec("INSERT INTO load_balancers (ap, service, max_count, public_addr) VALUES (?, ?, ?, ?)",
-		ap, service, maxCount, publicAddr)
+		ap, service, maxCount, nullString(publicAddr))
 	if err != nil {
 		return fmt.Errorf("DB exec failed: %v", err)
 	}
@@ -69,7 +200,14 @@ func (s *LoadBalancers) SetHttpHost(ap, name string, value *string) (err error)
 	return s.Set(ap, name, "http_host", value)
 }
 
+// SetHttpPath sets the HTTP path prefix. If value starts with `~`, it is a
+// regular expression route. Example: `~^/api/v[0-9]+/`.
 func (s *LoadBalancers) SetHttpPath(ap, name string, value string) (err error) {
+	if isPathRegexp(value) {
+		if _, err = compilePathRegexp(value); err != nil {
+			return
+		}
+	}
 	return s.Set(ap, name, "http_path", value)
 }
 
@@ -82,7 +220,98 @@ func (s *LoadBalancers) HttpUserAdd(ap, name, username, pasword string) (err err
 	if users, _, err = s.GetUsers(ap, name); err != nil {
 		return
 	}
-	return s.Set(ap, name, "http_auth_enabled", users.Set(username, pasword))
+	if err = users.Set(username, pasword); err != nil {
+		return
+	}
+	return s.Set(ap, name, "http_users", &users)
+}
+
+// HttpUserRehash replaces the password hash of user by the bcrypt hash of
+// password only if the stored hash still is oldHash, in a transaction, so that
+// a concurrent change of the users is not lost. Returns false if the stored
+// hash changed.
+func (s *LoadBalancers) HttpUserRehash(ap, name, username, oldHash, password string) (ok bool, err error) {
+	var tx *sql.Tx
+	if tx, err = s.DB.Begin(); err != nil {
+		return false, fmt.Errorf("DB Begin failed: %v", err)
+	}
+
+	defer func() {
+		if err != nil || !ok {
+			tx.Rollback()
+		}
+	}()
+
+	var (
+		raw   []byte
+		users HttpUsers
+	)
+	if err = tx.QueryRow("SELECT CAST(http_users AS BLOB) FROM load_balancers WHERE ap = ? AND service = ?", ap, name).Scan(&raw); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("DB Query failed: %v", err)
+	}
+	if err = users.Scan(raw); err != nil {
+		return false, fmt.Errorf("Scan auth failed: %v", err)
+	}
+	if hash, found := users.Hash(username); !found || hash != oldHash {
+		return false, nil
+	}
+	if err = users.Set(username, password); err != nil {
+		return
+	}
+
+	var (
+		result sql.Result
+		af     int64
+	)
+	if result, err = tx.Exec("UPDATE load_balancers SET http_users = ? WHERE ap = ? AND service = ? AND CAST(http_users AS BLOB) = ?",
+		&users, ap, name, raw); err != nil {
+		return false, fmt.Errorf("DB Exec failed: %v", err)
+	} else if af, err = result.RowsAffected(); err != nil {
+		return false, fmt.Errorf("DB Get Affected Rows failed: %v", err)
+	} else if af == 0 {
+		return false, nil
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("DB Commit failed: %v", err)
+	}
+	return true, nil
+}
+
+// HttpUsersImport imports the users of htpasswd file. Accepts the bcrypt,
+// APR1-MD5 and SHA1 formats. Non bcrypt hashes are rehashed on first
+// successful login.
+func (s *LoadBalancers) HttpUsersImport(ap, name string, r io.Reader) (count int, err error) {
+	var users HttpUsers
+	if users, _, err = s.GetUsers(ap, name); err != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(r)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return 0, fmt.Errorf("htpasswd line %d: bad format", i)
+		}
+		if err = users.SetHash(parts[0], parts[1]); err != nil {
+			return 0, fmt.Errorf("htpasswd line %d: %v", i, err)
+		}
+		count++
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, fmt.Errorf("read htpasswd failed: %v", err)
+	}
+	if count == 0 {
+		return
+	}
+	return count, s.Set(ap, name, "http_users", &users)
 }
 
 func (s *LoadBalancers) HttpUserRemove(ap, name string, username ...string) (err error) {
@@ -93,7 +322,188 @@ func (s *LoadBalancers) HttpUserRemove(ap, name string, username ...string) (err
 	if users, _, err = s.GetUsers(ap, name); err != nil {
 		return
 	}
-	return s.Set(ap, name, "http_auth_enabled", users.Remove(username...))
+	return s.Set(ap, name, "http_users", users.Remove(username...))
+}
+
+// SetBalancer sets the balancer name. See Balancers.
+func (s *LoadBalancers) SetBalancer(ap, name, value string) (err error) {
+	if _, err = NewBalancer(value); err != nil {
+		return
+	}
+	return s.Set(ap, name, "balancer", value)
+}
+
+// SetStickySessions enables HTTP sticky sessions by cookie.
+func (s *LoadBalancers) SetStickySessions(ap, name string, value bool) (err error) {
+	return s.Set(ap, name, "sticky_sessions", value)
+}
+
+// SetHealthCheck sets the active health check type. If value is blank,
+// disables it.
+func (s *LoadBalancers) SetHealthCheck(ap, name, value string) (err error) {
+	if _, err = NewHealthCheckConfig(&LoadBalancer{HealthCheck: value}); err != nil {
+		return
+	}
+	return s.Set(ap, name, "health_check", value)
+}
+
+// SetHealthCheckPath sets the HTTP health check GET path.
+func (s *LoadBalancers) SetHealthCheckPath(ap, name, value string) (err error) {
+	return s.Set(ap, name, "health_check_path", value)
+}
+
+// SetHealthCheckInterval sets the health check interval in seconds.
+func (s *LoadBalancers) SetHealthCheckInterval(ap, name string, value int) (err error) {
+	return s.Set(ap, name, "health_check_interval", value)
+}
+
+// SetHealthCheckTimeout sets the health check timeout in seconds.
+func (s *LoadBalancers) SetHealthCheckTimeout(ap, name string, value int) (err error) {
+	return s.Set(ap, name, "health_check_timeout", value)
+}
+
+// SetHealthyThreshold sets the consecutive passed checks to readmit an
+// unhealthy endpoint.
+func (s *LoadBalancers) SetHealthyThreshold(ap, name string, value int) (err error) {
+	return s.Set(ap, name, "healthy_threshold", value)
+}
+
+// SetUnhealthyThreshold sets the consecutive failed checks to eject an
+// endpoint.
+func (s *LoadBalancers) SetUnhealthyThreshold(ap, name string, value int) (err error) {
+	return s.Set(ap, name, "unhealthy_threshold", value)
+}
+
+// SetMaxRetries sets the max retries on another endpoint.
+func (s *LoadBalancers) SetMaxRetries(ap, name string, value int) (err error) {
+	return s.Set(ap, name, "max_retries", value)
+}
+
+// SetOutlierErrors sets the consecutive traffic errors to eject an endpoint.
+// Zero disables the passive outlier detection.
+func (s *LoadBalancers) SetOutlierErrors(ap, name string, value int) (err error) {
+	return s.Set(ap, name, "outlier_errors", value)
+}
+
+// SetOutlierEjectionTime sets the outlier ejection time in seconds.
+func (s *LoadBalancers) SetOutlierEjectionTime(ap, name string, value int) (err error) {
+	return s.Set(ap, name, "outlier_ejection_time", value)
+}
+
+// SetStripPrefix enables the HTTP path prefix stripping.
+func (s *LoadBalancers) SetStripPrefix(ap, name string, value bool) (err error) {
+	return s.Set(ap, name, "strip_prefix", value)
+}
+
+// SetPathRewrite sets the upstream request path rewrite. The pattern is a
+// regular expression and replacement can have `$1` group references. If
+// pattern is blank, disables it.
+func (s *LoadBalancers) SetPathRewrite(ap, name, pattern, replacement string) (err error) {
+	if pattern != "" {
+		if _, err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("bad path rewrite regexp %q: %v", pattern, err)
+		}
+	}
+	if err = s.Set(ap, name, "path_rewrite", pattern); err != nil {
+		return
+	}
+	return s.Set(ap, name, "path_rewrite_replacement", replacement)
+}
+
+// SetUpstreamHost sets the upstream request Host header. If value is blank,
+// uses the client request host.
+func (s *LoadBalancers) SetUpstreamHost(ap, name, value string) (err error) {
+	return s.Set(ap, name, "upstream_host", value)
+}
+
+// HttpHeaderAdd appends the header rule.
+func (s *LoadBalancers) HttpHeaderAdd(ap, name string, rule HeaderRule) (err error) {
+	if err = rule.Validate(); err != nil {
+		return
+	}
+	var lb *LoadBalancer
+	if lb, err = s.Get(ap, name); err != nil {
+		return
+	} else if lb == nil {
+		return fmt.Errorf("load balancer %s/%s not found", ap, name)
+	}
+	rules := append(lb.HttpHeaders, rule)
+	return s.Set(ap, name, "http_headers", &rules)
+}
+
+// HttpHeaderRemove removes the header rules by index, starting at 1.
+func (s *LoadBalancers) HttpHeaderRemove(ap, name string, index ...int) (err error) {
+	var lb *LoadBalancer
+	if lb, err = s.Get(ap, name); err != nil {
+		return
+	} else if lb == nil {
+		return fmt.Errorf("load balancer %s/%s not found", ap, name)
+	}
+	var (
+		remove = map[int]bool{}
+		rules  HeaderRules
+	)
+	for _, i := range index {
+		if i < 1 || i > len(lb.HttpHeaders) {
+			return fmt.Errorf("header rule %d not found", i)
+		}
+		remove[i] = true
+	}
+	for i, rule := range lb.HttpHeaders {
+		if !remove[i+1] {
+			rules = append(rules, rule)
+		}
+	}
+	return s.Set(ap, name, "http_headers", &rules)
+}
+
+// SetHttpMaxIdleConns sets the max idle pooled HTTP connections per endpoint.
+func (s *LoadBalancers) SetHttpMaxIdleConns(ap, name string, value int) (err error) {
+	return s.Set(ap, name, "http_max_idle_conns", value)
+}
+
+// SetHttpIdleTimeout sets the idle pooled HTTP connection lifetime in seconds.
+func (s *LoadBalancers) SetHttpIdleTimeout(ap, name string, value int) (err error) {
+	return s.Set(ap, name, "http_idle_timeout", value)
+}
+
+// SetHttpTimeout sets the response headers timeout in seconds. Zero disables
+// it.
+func (s *LoadBalancers) SetHttpTimeout(ap, name string, value int) (err error) {
+	return s.Set(ap, name, "http_timeout", value)
+}
+
+// SetTLSSNIHost sets the TLS passthrough server name. The server name must not
+// be used by other load balancer.
+func (s *LoadBalancers) SetTLSSNIHost(ap, name, value string) (err error) {
+	value = strings.ToLower(value)
+	if value != "" {
+		var other LoadBalancer
+		err = s.DB.QueryRow("SELECT ap, service FROM load_balancers WHERE tls_sni_host = ? AND NOT (ap = ? AND service = ?)",
+			value, ap, name).Scan(&other.Ap, &other.Service)
+		if err == nil {
+			return fmt.Errorf("TLS SNI host %q is used by `%s:%s`", value, other.Ap, other.Service)
+		} else if err != sql.ErrNoRows {
+			return fmt.Errorf("DB Query failed: %v", err)
+		}
+	}
+	return s.Set(ap, name, "tls_sni_host", value)
+}
+
+func (s *LoadBalancers) SetPublicUDPAddr(ap, name, value string) (err error) {
+	return s.Set(ap, name, "public_udp_addr", value)
+}
+
+// SetAllow sets the source networks accepted by public TCP listener and HTTP
+// route. The empty value accepts all sources.
+func (s *LoadBalancers) SetAllow(ap, name string, value IPNets) (err error) {
+	return s.Set(ap, name, "allow_cidrs", &value)
+}
+
+// SetDeny sets the source networks rejected by public TCP listener and HTTP
+// route.
+func (s *LoadBalancers) SetDeny(ap, name string, value IPNets) (err error) {
+	return s.Set(ap, name, "deny_cidrs", &value)
 }
 
 func (s *LoadBalancers) SetUnixSocket(ap, name string, value bool) (err error) {
@@ -101,15 +511,23 @@ func (s *LoadBalancers) SetUnixSocket(ap, name string, value bool) (err error) {
 }
 
 func (s *LoadBalancers) SetMaxCount(ap, name string, value int) (err error) {
-	return s.Set(ap, name, "max_xount", value)
+	return s.Set(ap, name, "max_count", value)
 }
 
 func (s *LoadBalancers) SetPublicAddr(ap, name, value string) (err error) {
-	return s.Set(ap, name, "public_addr", value)
+	return s.Set(ap, name, "public_addr", nullString(value))
+}
+
+// nullString returns nil if value is blank. Used by unique nullable columns.
+func nullString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
 }
 
 func (s *LoadBalancers) Set(ap, name, field string, value interface{}) (err error) {
-	sqls := "UPDATE load_balancers SET " + field + " = ? WHERE ap = ? AND name = ? "
+	sqls := "UPDATE load_balancers SET " + field + " = ? WHERE ap = ? AND service = ? "
 
 	var stmt *sql.Stmt
 
@@ -119,7 +537,7 @@ func (s *LoadBalancers) Set(ap, name, field string, value interface{}) (err erro
 
 	defer stmt.Close()
 
-	if result, err := stmt.Exec(ap, name, value); err != nil {
+	if result, err := stmt.Exec(value, ap, name); err != nil {
 		return fmt.Errorf("DB Exec failed: %v", err)
 	} else if af, err := result.RowsAffected(); err != nil {
 		err = fmt.Errorf("DB Get Affected Rows failed: %v", err)
@@ -150,8 +568,18 @@ func (s *LoadBalancers) List(cb func(i int, lb *LoadBalancer) error, filter *Loa
 		}
 	}
 
-	rows, err := s.DB.Query("SELECT ap, service, max_count, public_addr, http_host, http_path FROM load_balancers WHERE "+
-		strings.Join(where, " AND ")+" ORDER BY ap, service ASC", args...)
+	var whereSql string
+	if len(where) > 0 {
+		whereSql = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	rows, err := s.DB.Query("SELECT ap, service, max_count, public_addr, http_host, http_path, unix_socket, "+
+		"http_auth_enabled, balancer, sticky_sessions, health_check, health_check_path, health_check_interval, "+
+		"health_check_timeout, healthy_threshold, unhealthy_threshold, max_retries, outlier_errors, "+
+		"outlier_ejection_time, strip_prefix, path_rewrite, path_rewrite_replacement, upstream_host, "+
+		"http_headers, http_max_idle_conns, http_idle_timeout, http_timeout, tls_sni_host, public_udp_addr, "+
+		"allow_cidrs, deny_cidrs FROM load_balancers"+whereSql+
+		" ORDER BY ap, service ASC", args...)
 	if err != nil {
 		return fmt.Errorf("DB Query failed: %v", err)
 	}
@@ -160,10 +588,16 @@ func (s *LoadBalancers) List(cb func(i int, lb *LoadBalancer) error, filter *Loa
 
 	for i := 1; rows.Next(); i++ {
 		var lb LoadBalancer
-		if err = rows.Scan(&lb.Ap, &lb.Service, &lb.MaxCount, &lb.PublicAddr, &lb.HttpHost, &lb.HttpPath); err != nil {
+		if err = rows.Scan(&lb.Ap, &lb.Service, &lb.MaxCount, &lb.PublicAddr, &lb.HttpHost, &lb.HttpPath, &lb.UnixSocket,
+			&lb.HttpAuthEnabled, &lb.Balancer, &lb.StickySessions, &lb.HealthCheck, &lb.HealthCheckPath,
+			&lb.HealthCheckInterval, &lb.HealthCheckTimeout, &lb.HealthyThreshold, &lb.UnhealthyThreshold,
+			&lb.MaxRetries, &lb.OutlierErrors, &lb.OutlierEjectionTime, &lb.StripPrefix, &lb.PathRewrite,
+			&lb.PathRewriteReplacement, &lb.UpstreamHost, &lb.HttpHeaders,
+			&lb.HttpMaxIdleConns, &lb.HttpIdleTimeout, &lb.HttpTimeout, &lb.TLSSNIHost, &lb.PublicUDPAddr,
+			&lb.Allow, &lb.Deny); err != nil {
 			return fmt.Errorf("Scan Load Balancer %d failed: %v", i, err)
 		}
-		if lb.HttpPath != "" {
+		if lb.HttpPath != "" && !isPathRegexp(lb.HttpPath) {
 			lb.HttpPath = cleanPth(lb.HttpPath)
 		}
 		if err = cb(i, &lb); err != nil {
@@ -202,3 +636,12 @@ func (s *LoadBalancers) GetUsers(ap, service string) (users HttpUsers, enabled b
 	}
 	return
 }
+
+// HasHttpHost reports whether any load balancer has the HTTP host.
+func (s *LoadBalancers) HasHttpHost(host string) (ok bool, err error) {
+	var count int
+	if err = s.DB.QueryRow("SELECT COUNT(*) FROM load_balancers WHERE lower(http_host) = ?", strings.ToLower(host)).Scan(&count); err != nil {
+		return false, fmt.Errorf("DB Query failed: %v", err)
+	}
+	return count > 0, nil
+}
@@ -119,12 +119,14 @@ type AddrListener struct {
 	AddrS string
 	net.Listener
 	StrPrefix string
-	str       string
+	// ProxyProtocol reads the PROXY protocol header of accepted connections.
+	ProxyProtocol bool
+	str           string
 }
 
 func (l AddrListener) ProtoAddr() string {
 	if l.str == "" {
-		return l.ProtoAddr()
+		return l.AddrS
 	}
 	return l.str
 }
@@ -137,11 +139,19 @@ func (l *AddrListener) Listen() (err error) {
 	l.Listener, err = net.Listen("tcp", l.AddrS)
 	if err != nil {
 		err = errors.New(l.String() + " listen failed: " + err.Error())
+		return
 	}
 	l.str = l.Listener.Addr().String()
 	return
 }
 
+func (l *AddrListener) Accept() (conn net.Conn, err error) {
+	if conn, err = l.Listener.Accept(); err == nil && l.ProxyProtocol {
+		conn = newProxyProtocolConn(conn)
+	}
+	return
+}
+
 func (l *AddrListener) Close() (err error) {
 	if l.Listener != nil {
 		defer log.Println(l.String(), "Closed")
@@ -233,7 +243,19 @@ func (l *ChanListener) Dial(ctx context.Context, remoteAddr string) (con net.Con
 	or, ow := io.Pipe()
 
 	rConn := &VirtualCon{Writer: ow, Reader: ir, RAddr: radd, LAddr: laddr}
-	l.src <- rConn
+	src := l.src
+	if src == nil {
+		return nil, errors.New(l.ProtoAddr() + " is closed")
+	}
+	if ctx == nil {
+		src <- rConn
+	} else {
+		select {
+		case src <- rConn:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
 	lConn := &VirtualCon{Writer: iw, Reader: or, RAddr: laddr, LAddr: radd}
 	return lConn, nil
 }
@@ -2,10 +2,16 @@ package server
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log"
 	"net"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/moisespsena-go/xssh/common"
 )
@@ -17,36 +23,166 @@ type Node struct {
 	Ap, Service string
 	Listeners   []Listener
 	EndPoints   map[string]*NodeServiceListener
-	mu          sync.Mutex
+	// LB is the current load balancer configuration. Changed by Reload.
+	LB *LoadBalancer
+
+	unixListener   *UnixListener
+	publicListener *AddrListener
+	udpRelay       *common.UDPRelay
+	balancer       Balancer
+	healthChecker  *healthChecker
+	transport      *httpTransport
+	closed         bool
+	mu             sync.Mutex
+	// denied is the count of connections and requests rejected by the
+	// load balancer source networks.
+	denied uint64
+	// passwords caches the HTTP users password checks.
+	passwords passwordCache
+	// domainRoute is the HTTP route of HttpDomain subdomain. Changed by
+	// Reload.
+	domainRoute *LB
+}
+
+type endPointContextKey struct{}
+
+// EndPointSelection is the context value of NextDialSl used by sticky sessions
+// and retries. If ID is set, the endpoint with it is preferred. The Tried
+// endpoints are skipped. After dial, ID is the dialed endpoint.
+type EndPointSelection struct {
+	ID    string
+	Tried []string
+
+	endPoint *NodeServiceListener
+}
+
+func (sel *EndPointSelection) tried(sl *NodeServiceListener) bool {
+	if sel == nil {
+		return false
+	}
+	for _, id := range sel.Tried {
+		if id == sl.ID() {
+			return true
+		}
+	}
+	return false
 }
 
-func (n Node) CloseEndPoint(addr string) {
-	if sl, ok := n.EndPoints[addr]; ok {
+// retry marks the dialed endpoint as tried.
+func (sel *EndPointSelection) retry() {
+	if sel.ID != "" {
+		sel.Tried = append(sel.Tried, sel.ID)
+	}
+	sel.ID, sel.endPoint = "", nil
+}
+
+// WithEndPointSelection returns the context with the endpoint selection.
+func WithEndPointSelection(ctx context.Context, sel *EndPointSelection) context.Context {
+	return context.WithValue(ctx, endPointContextKey{}, sel)
+}
+
+func (n *Node) CloseEndPoint(addr string) {
+	n.nodes.mu.RLock()
+	sl, ok := n.EndPoints[addr]
+	n.nodes.mu.RUnlock()
+	if ok {
 		n.nodes.Remove(&LoadBalancer{Ap: n.Ap, Service: n.Service}, sl)
 	}
 }
 
-func (n Node) proxy(conn net.Conn) {
+// DrainEndPoint stops routing new connections to endpoint and returns the
+// connections count still active. The first call waits in background for the
+// active connections to finish or the timeout, to close the idle pooled
+// connections of endpoint.
+func (n *Node) DrainEndPoint(addr string, timeout time.Duration) (active int) {
+	n.nodes.mu.RLock()
+	sl, ok := n.EndPoints[addr]
+	n.nodes.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	sl.mu.Lock()
+	draining := sl.draining
+	sl.draining = true
+	sl.mu.Unlock()
+
+	active = sl.Connections()
+	if draining {
+		return
+	}
+	n.nodes.saveEndPoint(n, sl)
+	log.Println(n.String(), "EP{"+addr+"}: draining", active, "connections")
+	go n.waitDrain(sl, addr, timeout)
+	return
+}
+
+func (n *Node) waitDrain(sl *NodeServiceListener, addr string, timeout time.Duration) {
+	// the pooled HTTP connections are not active connections
+	defer n.closeIdleConnections()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+
+	for active := sl.Connections(); active > 0; {
+		select {
+		case <-deadline:
+			log.Println(n.String(), "EP{"+addr+"}: drain timeout with", active, "active connections")
+			return
+		case <-ticker.C:
+			active = sl.Connections()
+		}
+	}
+	log.Println(n.String(), "EP{"+addr+"}: drained")
+}
+
+// proxy proxies the client connection to an endpoint. If the endpoint dial
+// fails (or the AP reports its backend dial failure, see
+// common.DialStatusRequestType), the connection is dialed on another endpoint
+// up to MaxRetries times. The client data is not read before the endpoint is
+// connected, so it is never replayed.
+func (n *Node) proxy(conn net.Conn) {
 	prfx := n.String()
+	if pc, ok := conn.(*proxyProtocolConn); ok {
+		if err := pc.readHeader(); err != nil {
+			log.Println(prfx, "<"+pc.Conn.RemoteAddr().String()+">", err.Error())
+			conn.Close()
+			return
+		}
+	}
 	defer func() {
 		conn.Close()
 		log.Println(prfx, "closed")
 	}()
 	log.Println(prfx, "connected")
 
-	n.mu.Lock()
-	if len(n.EndPoints) == 0 {
-		log.Println(prfx, "no have endpoints")
-		return
+	var (
+		sel              = &EndPointSelection{}
+		ctx              = WithEndPointSelection(context.Background(), sel)
+		maxRetries, _, _ = n.outlierConfig()
+		sl               *NodeServiceListener
+		rCon             net.Conn
+		err              error
+	)
+	for attempt := 0; ; attempt++ {
+		if sl, rCon, err = n.NextDialSl(ctx, conn.RemoteAddr().String()); err == nil {
+			break
+		}
+		if sel.endPoint == nil {
+			log.Println(prfx, "dial failed:", err.Error())
+			return
+		}
+		n.endPointFailed(sel.endPoint, err)
+		if !isEndPointDialError(err) || attempt >= maxRetries {
+			log.Println(prfx, "dial failed:", err.Error())
+			return
+		}
+		log.Println(prfx, "EP{"+sel.endPoint.key+"}: dial failed, retrying on another endpoint:", err.Error())
+		sel.retry()
 	}
+	sl.reportSuccess()
 
-	n.mu.Unlock()
-
-	sl, rCon, err := n.NextDialSl(nil, conn.RemoteAddr().String())
-	if err != nil {
-		log.Println(prfx, "dial failed:", err.Error())
-		return
-	}
 	addrs := sl.Addr().String()
 	rprfx := prfx + " " + sl.Name + "@" + "{" + addrs + "}"
 
@@ -62,77 +198,412 @@ func (n Node) proxy(conn net.Conn) {
 	).Sync()
 }
 
-func (n Node) String() string {
+// loadBalancer returns the current load balancer configuration.
+func (n *Node) loadBalancer() *LoadBalancer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.LB
+}
+
+// domainLB returns the HTTP route of HttpDomain subdomain: the load balancer
+// mounted to root path, regardless of its HTTP host and path.
+func (n *Node) domainLB() *LB {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.domainRoute
+}
+
+// httpTransport returns the pooled HTTP transport.
+func (n *Node) httpTransport() *httpTransport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.transport == nil {
+		lb := n.LB
+		if lb == nil {
+			lb = &LoadBalancer{}
+		}
+		n.transport = newHttpTransport(n, newHttpTransportConfig(lb))
+	}
+	return n.transport
+}
+
+// closeIdleConnections closes the idle connections of HTTP transport.
+func (n *Node) closeIdleConnections() {
+	n.mu.Lock()
+	t := n.transport
+	n.mu.Unlock()
+	if t != nil {
+		t.CloseIdleConnections()
+	}
+}
+
+func (n *Node) String() string {
 	return "LB{" + n.Ap + ":" + n.Service + "}"
 }
 
-func (n Node) Listen() (err error) {
+func (n *Node) Listen() (err error) {
 	if err = n.ChanListener.Listen(); err != nil {
 		return
 	}
-	var addrs = []string{n.ChanListener.ProtoAddr()}
-	for _, l := range n.Listeners {
-		if err = l.Listen(); err == nil {
-			addrs = append(addrs, l.ProtoAddr())
+	log.Println(n.String(), "listening on", "{"+n.ChanListener.ProtoAddr()+"}")
+	return
+}
+
+// Reload applies the load balancer configuration: opens or closes the unix
+// socket and public TCP and UDP listeners and remounts the HTTP and TLS SNI routes. The accepted
+// connections are not closed. The listeners that failed to start are retried
+// even if the configuration is unchanged. The MaxCount applies to the next AP
+// registrations.
+func (n *Node) Reload(lb *LoadBalancer) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return
+	}
+
+	old := n.LB
+	if old == nil {
+		old = &LoadBalancer{Ap: n.Ap, Service: n.Service}
+	}
+	same := n.LB != nil && old.sameConfig(lb)
+	n.LB = lb
+
+	if lb.Balancer != old.Balancer {
+		var err error
+		if n.balancer, err = NewBalancer(lb.Balancer); err != nil {
+			log.Println(n.String(), err.Error()+": using", DefaultBalancer)
+			n.balancer, _ = NewBalancer(DefaultBalancer)
 		}
 	}
-	log.Println(n.String(), "listening on", "{"+strings.Join(addrs, ", ")+"}")
-	return
+
+	if lb.UnixSocket && n.unixListener == nil {
+		ul := &UnixListener{
+			SocketPath: filepath.Join(n.Dir, n.Ap, n.Service+".sock"),
+			SockPerm:   n.nodes.SockPerm,
+		}
+		ul.Str = n.String() + "@" + ul.SocketPath
+		if n.startListener(ul) {
+			n.unixListener = ul
+		}
+	} else if !lb.UnixSocket && n.unixListener != nil {
+		n.stopListener(n.unixListener)
+		n.unixListener = nil
+	}
+
+	if publicAddr := lb.publicAddr(); publicAddr != old.publicAddr() || (publicAddr != "" && n.publicListener == nil) {
+		if n.publicListener != nil {
+			n.stopListener(n.publicListener)
+			n.publicListener = nil
+		}
+		if publicAddr != "" {
+			pl := &AddrListener{AddrS: publicAddr, ProxyProtocol: n.nodes.ProxyProtocol}
+			pl.StrPrefix = n.String() + "@"
+			if n.startListener(pl) {
+				n.publicListener = pl
+			}
+		}
+	}
+
+	if udpAddr := lb.PublicUDPAddr; udpAddr != old.PublicUDPAddr || (udpAddr != "" && n.udpRelay == nil) {
+		if n.udpRelay != nil {
+			n.udpRelay.Close()
+			n.udpRelay = nil
+		}
+		if udpAddr != "" {
+			n.udpRelay = n.listenUDP(udpAddr)
+		}
+	}
+
+	if same {
+		return
+	}
+
+	domainLB := *lb
+	domainLB.HttpPath = "/"
+	var err error
+	if n.domainRoute, err = newLB(&domainLB, n); err != nil {
+		log.Println(n.String(), "HTTP domain route failed:", err.Error())
+	}
+
+	if host := old.httpHost(); host != "" && (host != lb.httpHost() || old.HttpPath != lb.HttpPath) {
+		n.nodes.HttpHosts.Unmount(host, old.HttpPath, n)
+	}
+	if host := lb.httpHost(); host != "" {
+		n.nodes.HttpHosts.GetOrRegister(host).Set(lb, n)
+	}
+
+	if n.nodes.SNIHosts != nil {
+		if host := old.tlsSNIHost(); host != "" && host != lb.tlsSNIHost() {
+			n.nodes.SNIHosts.Unset(host, n)
+		}
+		if host := lb.tlsSNIHost(); host != "" {
+			n.nodes.SNIHosts.Set(host, n)
+		}
+	}
+
+	n.reloadHealthCheck(old, lb)
+
+	if n.transport != nil && newHttpTransportConfig(lb) != n.transport.cfg {
+		n.transport.CloseIdleConnections()
+		n.transport = nil
+	}
 }
 
-func (n Node) NextDial(ctx context.Context, remoteAddr string) (conn net.Conn, err error) {
+// listenUDP starts the public UDP listener. The datagrams of each client are
+// relayed to an endpoint selected by balancer. See common.UDPRelay.
+func (n *Node) listenUDP(addr string) *common.UDPRelay {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Println(n.String(), "UDP listen on", "{"+addr+"}", "failed:", err.Error())
+		return nil
+	}
+	relay := &common.UDPRelay{
+		Name: n.String() + "@udp:" + conn.LocalAddr().String(),
+		Conn: conn,
+		Accept: func(client net.Addr) bool {
+			return n.allowSource(n.loadBalancer(), client.String())
+		},
+		Dial: func(client net.Addr) (net.Conn, error) {
+			return n.NextDial(nil, client.String())
+		},
+	}
+	log.Println(relay.Name, "listening")
+	go func() {
+		relay.Serve()
+		log.Println(relay.Name, "closed")
+	}()
+	return relay
+}
+
+func (n *Node) reloadHealthCheck(old, lb *LoadBalancer) {
+	oldCfg, _ := NewHealthCheckConfig(old)
+	cfg, err := NewHealthCheckConfig(lb)
+	if err != nil {
+		log.Println(n.String(), err.Error()+": health check disabled")
+	}
+	if n.healthChecker != nil && cfg != nil && oldCfg != nil && *cfg == *oldCfg {
+		return
+	}
+	if n.healthChecker != nil {
+		n.healthChecker.Stop()
+		n.healthChecker = nil
+	}
+	if cfg == nil {
+		for _, sl := range n.endPoints() {
+			if sl.resetHealth() {
+				n.nodes.saveEndPoint(n, sl)
+			}
+		}
+		return
+	}
+	log.Println(n.String(), "health check:", cfg.Type, "every", cfg.Interval)
+	n.healthChecker = newHealthChecker(n, *cfg)
+}
+
+// endPoints returns the endpoints sorted by key.
+func (n *Node) endPoints() []*NodeServiceListener {
+	n.nodes.mu.RLock()
+	endPoints := make([]*NodeServiceListener, 0, len(n.EndPoints))
+	for _, sl := range n.EndPoints {
+		endPoints = append(endPoints, sl)
+	}
+	n.nodes.mu.RUnlock()
+
+	sort.Slice(endPoints, func(i, j int) bool {
+		return endPoints[i].key < endPoints[j].key
+	})
+	return endPoints
+}
+
+func (n *Node) startListener(l Listener) bool {
+	if err := l.Listen(); err != nil {
+		log.Println(n.String(), err.Error())
+		return false
+	}
+	log.Println(n.String(), "listening on", "{"+l.ProtoAddr()+"}")
+	n.Listeners = append(n.Listeners, l)
+	go n.forever(l)
+	return true
+}
+
+func (n *Node) stopListener(l Listener) {
+	for i, l2 := range n.Listeners {
+		if l2 == l {
+			n.Listeners = append(n.Listeners[:i], n.Listeners[i+1:]...)
+			break
+		}
+	}
+	l.Close()
+}
+
+func (n *Node) NextDial(ctx context.Context, remoteAddr string) (conn net.Conn, err error) {
 	_, conn, err = n.NextDialSl(ctx, remoteAddr)
 	return
 }
 
-func (n Node) NextDialSl(ctx context.Context, remoteAddr string) (sl *NodeServiceListener, conn net.Conn, err error) {
-	var (
-		first = true
-		min   int
-	)
+// NextEndPoint returns the endpoint selected by balancer. The unhealthy
+// endpoints are skipped, unless all are unhealthy. The ctx can have the
+// EndPointSelection value, updated with the selected endpoint.
+func (n *Node) NextEndPoint(ctx context.Context, remoteAddr string) (sl *NodeServiceListener, err error) {
+	var sel *EndPointSelection
+	if ctx != nil {
+		sel, _ = ctx.Value(endPointContextKey{}).(*EndPointSelection)
+	}
+
+	all := n.endPoints()
+	if len(all) == 0 {
+		return nil, errors.New("no have endpoints")
+	}
 
-	for _, sl2 := range n.EndPoints {
-		if first {
-			min = sl2.connections
-			sl = sl2
-			first = false
-		} else {
-			if sl2.connections < min {
+	endPoints := make([]*NodeServiceListener, 0, len(all))
+	for _, sl2 := range all {
+		if sl2.available() && !sel.tried(sl2) {
+			if sel != nil && sel.ID != "" && sl2.ID() == sel.ID {
 				sl = sl2
 			}
+			endPoints = append(endPoints, sl2)
 		}
 	}
+	if len(endPoints) == 0 {
+		if sel != nil && len(sel.Tried) > 0 {
+			return nil, errors.New("all endpoints failed")
+		}
+		log.Println(n.String(), "all endpoints are unavailable")
+		for _, sl2 := range all {
+			if !sl2.Draining() {
+				endPoints = append(endPoints, sl2)
+			}
+		}
+		if len(endPoints) == 0 {
+			return nil, errors.New("all endpoints are draining")
+		}
+	}
+
+	if sl == nil {
+		n.mu.Lock()
+		balancer := n.balancer
+		n.mu.Unlock()
+		sl = balancer.Next(endPoints, remoteAddr)
+	}
+
+	if sel != nil {
+		sel.ID, sel.endPoint = sl.ID(), sl
+	}
+	return
+}
 
+// NextDialSl dials the endpoint selected by balancer. See NextEndPoint.
+func (n *Node) NextDialSl(ctx context.Context, remoteAddr string) (sl *NodeServiceListener, conn net.Conn, err error) {
+	if sl, err = n.NextEndPoint(ctx, remoteAddr); err != nil {
+		return
+	}
 	if conn, err = sl.Dial(nil, remoteAddr); err != nil {
-		sl = nil
+		return nil, nil, err
 	}
 	return
 }
 
-func (n Node) forever(ln Listener) {
+// endPointByID returns the endpoint by ID or nil if not exists.
+func (n *Node) endPointByID(id string) *NodeServiceListener {
+	for _, sl := range n.endPoints() {
+		if sl.ID() == id {
+			return sl
+		}
+	}
+	return nil
+}
+
+// outlierConfig returns the retry and passive outlier detection configuration.
+func (n *Node) outlierConfig() (maxRetries, maxErrors int, ejectionTime time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.LB == nil {
+		return
+	}
+	return n.LB.MaxRetries, n.LB.OutlierErrors, time.Duration(n.LB.OutlierEjectionTime) * time.Second
+}
+
+// endPointFailed registers the traffic error of endpoint. After the
+// consecutive errors threshold, the endpoint is ejected temporarily.
+func (n *Node) endPointFailed(sl *NodeServiceListener, err error) {
+	_, maxErrors, ejectionTime := n.outlierConfig()
+	if sl.reportError(err, maxErrors, ejectionTime) {
+		log.Println(n.String(), "EP{"+sl.key+"}: ejected for", ejectionTime, "after", maxErrors, "consecutive errors:", err.Error())
+		n.nodes.saveEndPoint(n, sl)
+	}
+}
+
+// allowSource reports whether the client addr is accepted by the source
+// networks of load balancer lb. The denied clients are counted and logged.
+func (n *Node) allowSource(lb *LoadBalancer, addr string) bool {
+	if lb == nil || lb.sourceAllowed(net.ParseIP(clientIP(addr))) {
+		return true
+	}
+	denied := atomic.AddUint64(&n.denied, 1)
+	log.Println(n.String(), "<"+addr+">", "source denied, total denied:", denied)
+	return false
+}
+
+func (n *Node) forever(ln Listener) {
+	_, public := ln.(*AddrListener)
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			if !strings.Contains(err.Error(), "use of closed network connection") {
+			if err != io.EOF && !strings.Contains(err.Error(), "use of closed network connection") {
 				log.Println(ln.String(), "accept failed:", err.Error())
 			}
 			return
 		}
 
-		go n.proxy(conn)
+		if !public {
+			go n.proxy(conn)
+			continue
+		}
+		go func(conn net.Conn) {
+			// the RemoteAddr may read the PROXY protocol header
+			if !n.allowSource(n.loadBalancer(), conn.RemoteAddr().String()) {
+				conn.Close()
+				return
+			}
+			n.proxy(conn)
+		}(conn)
 	}
 }
 
-func (n Node) Forever() {
-	for _, l := range n.Listeners {
-		go n.forever(l)
-	}
+func (n *Node) Forever() {
 	n.forever(n)
 }
 
-func (n Node) Close() (err error) {
+func (n *Node) Close() (err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.closed = true
 	for _, l := range n.Listeners {
 		l.Close()
 	}
-	return
+	n.Listeners = nil
+	n.unixListener, n.publicListener = nil, nil
+	if n.udpRelay != nil {
+		n.udpRelay.Close()
+		n.udpRelay = nil
+	}
+	if n.healthChecker != nil {
+		n.healthChecker.Stop()
+		n.healthChecker = nil
+	}
+	if n.transport != nil {
+		n.transport.CloseIdleConnections()
+		n.transport = nil
+	}
+	if n.LB != nil {
+		if host := n.LB.httpHost(); host != "" {
+			n.nodes.HttpHosts.Unmount(host, n.LB.HttpPath, n)
+		}
+		if host := n.LB.tlsSNIHost(); host != "" && n.nodes.SNIHosts != nil {
+			n.nodes.SNIHosts.Unset(host, n)
+		}
+	}
+	return n.ChanListener.Close()
 }
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordPrefixBcrypt = "$2"
+	passwordPrefixApr1   = "$apr1$"
+	passwordPrefixSha    = "{SHA}"
+)
+
+// dummyPasswordHash is compared when the user does not exists, to spend the
+// same time of an existing user.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("hash password failed: %v", err)
+	}
+	return string(hash), nil
+}
+
+// isPasswordHashed reports whether the stored value is a bcrypt hash.
+func isPasswordHashed(stored string) bool {
+	return strings.HasPrefix(stored, passwordPrefixBcrypt)
+}
+
+// isHtpasswdHash reports whether the stored value is a supported htpasswd
+// hash format: bcrypt, APR1-MD5 or SHA1.
+func isHtpasswdHash(stored string) bool {
+	return isPasswordHashed(stored) || strings.HasPrefix(stored, passwordPrefixApr1) || strings.HasPrefix(stored, passwordPrefixSha)
+}
+
+// checkPassword compares password with the stored value in constant time.
+// Values not in htpasswd hash formats are legacy plain text passwords.
+func checkPassword(stored, password string) bool {
+	switch {
+	case isPasswordHashed(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	case strings.HasPrefix(stored, passwordPrefixApr1):
+		salt := strings.SplitN(strings.TrimPrefix(stored, passwordPrefixApr1), "$", 2)[0]
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(apr1(password, salt))) == 1
+	case strings.HasPrefix(stored, passwordPrefixSha):
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(passwordPrefixSha+base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	default:
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	}
+}
+
+// passwordCacheTTL is the max time of a cached successful password check.
+const passwordCacheTTL = time.Minute
+
+// passwordCacheMax is the entries count that triggers the removal of the
+// expired entries.
+const passwordCacheMax = 1024
+
+// passwordCache caches the successful password checks, so that each request
+// of a client does not spend a bcrypt compare. The entry key is the digest of
+// user, stored hash and password: a changed or removed user doesn't match.
+type passwordCache struct {
+	entries map[[sha256.Size]byte]time.Time
+	mu      sync.Mutex
+}
+
+// Match checks the user password like HttpUsers.Match, using the cached
+// successful checks.
+func (c *passwordCache) Match(users *HttpUsers, user, password string) bool {
+	stored, ok := users.Hash(user)
+	if !ok {
+		return users.Match(user, password)
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + stored + "\x00" + password))
+	now := time.Now()
+
+	c.mu.Lock()
+	expires, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(expires) {
+		return true
+	}
+
+	if !checkPassword(stored, password) {
+		return false
+	}
+
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = map[[sha256.Size]byte]time.Time{}
+	} else if len(c.entries) >= passwordCacheMax {
+		for key, expires := range c.entries {
+			if !now.Before(expires) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	if len(c.entries) < passwordCacheMax {
+		c.entries[key] = now.Add(passwordCacheTTL)
+	}
+	c.mu.Unlock()
+	return true
+}
+
+const apr1Itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// apr1 is the Apache APR1-MD5 password algorithm (`htpasswd -m`).
+func apr1(password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[0:8]
+	}
+	pw := []byte(password)
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(passwordPrefixApr1))
+	ctx.Write([]byte(salt))
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(altSum)
+		} else {
+			ctx.Write(altSum[0:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[0:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		c := md5.New()
+		if i&1 == 1 {
+			c.Write(pw)
+		} else {
+			c.Write(final)
+		}
+		if i%3 != 0 {
+			c.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			c.Write(pw)
+		}
+		if i&1 == 1 {
+			c.Write(final)
+		} else {
+			c.Write(pw)
+		}
+		final = c.Sum(nil)
+	}
+
+	var out []byte
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out = append(out, apr1Itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint32(final[i[0]])<<16|uint32(final[i[1]])<<8|uint32(final[i[2]]), 4)
+	}
+	to64(uint32(final[11]), 2)
+	return passwordPrefixApr1 + salt + "$" + string(out)
+}
@@ -36,9 +36,14 @@ func proxy(register *DefaultReversePortForwardingRegister, ctx ssh.Context, conn
 					err = errors.New("get `ssh` service connection for AP failed: " + err.Error())
 				}
 			}()
-			var proxyAddr string
-			if ln, err := register.GetListener(apName, common.SrvcSSH); err == nil {
+			var (
+				proxyAddr       string
+				hostKeyCallback gossh.HostKeyCallback
+				user, _         = ctx.Value("user:name").(string)
+			)
+			if ln, err := register.GetUserListener(user, apName, common.SrvcSSH); err == nil {
 				proxyAddr = ln.Addr().String()
+				hostKeyCallback = ln.HostKeyCallback(register.ApKnownHosts)
 			} else {
 				lp("get listen failed:", err)
 				return err
@@ -47,7 +52,7 @@ func proxy(register *DefaultReversePortForwardingRegister, ctx ssh.Context, conn
 			sshConfig := &gossh.ClientConfig{
 				User: ctx.Value("proxy:user").(string),
 			}
-			sshConfig.HostKeyCallback = gossh.InsecureIgnoreHostKey()
+			sshConfig.HostKeyCallback = hostKeyCallback
 
 			client, err = gossh.Dial("tcp", proxyAddr, sshConfig)
 			if err != nil {
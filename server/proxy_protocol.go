@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/moisespsena-go/xssh/common"
+)
+
+// ProxyHeaderTimeout is the max time to read the PROXY protocol header of
+// accepted connections.
+var ProxyHeaderTimeout = 10 * time.Second
+
+// proxyProtocolConn reads the PROXY protocol header of connection accepted
+// behind a proxy (HAProxy or cloud load balancers) on first Read or
+// RemoteAddr call. The RemoteAddr is the original client address.
+type proxyProtocolConn struct {
+	net.Conn
+	br     *bufio.Reader
+	header *common.ProxyHeader
+	err    error
+	once   sync.Once
+}
+
+func newProxyProtocolConn(conn net.Conn) *proxyProtocolConn {
+	return &proxyProtocolConn{Conn: conn, br: bufio.NewReader(conn)}
+}
+
+// readHeader reads the header once.
+func (c *proxyProtocolConn) readHeader() error {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout))
+		if c.header, c.err = common.ReadProxyHeader(c.br); c.err != nil {
+			c.err = fmt.Errorf("read PROXY protocol header failed: %v", c.err)
+		}
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+	return c.err
+}
+
+func (c *proxyProtocolConn) Read(p []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+	return c.br.Read(p)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	if c.readHeader() == nil && c.header != nil {
+		return c.header.Src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	if c.readHeader() == nil && c.header != nil {
+		return c.header.Dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// headerConn writes the header before the connection data. The header is
+// written in background, so that the dial does not wait the AP.
+type headerConn struct {
+	net.Conn
+	mu  sync.Mutex
+	err error
+}
+
+func newHeaderConn(conn net.Conn, header []byte) *headerConn {
+	c := &headerConn{Conn: conn}
+	c.mu.Lock()
+	go func() {
+		defer c.mu.Unlock()
+		_, c.err = conn.Write(header)
+	}()
+	return c
+}
+
+func (c *headerConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Write(p)
+}
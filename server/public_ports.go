@@ -0,0 +1,168 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PublicPort binds the server TCP address to the service of AP. The
+// connections are accepted only from the Allow networks. If Allow is empty,
+// all sources are accepted.
+type PublicPort struct {
+	Addr      string    `json:"addr"`
+	Ap        string    `json:"ap"`
+	Service   string    `json:"service"`
+	Allow     IPNets    `json:"allow"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (p PublicPort) String() string {
+	s := p.Addr + " -> " + p.Ap + "/" + p.Service
+	if len(p.Allow) > 0 {
+		s += " allow " + p.Allow.String()
+	}
+	return s
+}
+
+type PublicPortsFilter struct {
+	Ap string
+}
+
+// PublicPorts stores the server public TCP ports of AP services. The running
+// server applies the changes on load balancers reload.
+type PublicPorts struct {
+	DB *DB
+}
+
+func NewPublicPorts(db *DB) *PublicPorts {
+	return &PublicPorts{DB: db}
+}
+
+// parsePortRange parses the `HOST:PORT` or `HOST:FROM-TO` address.
+func parsePortRange(addr string) (host string, from, to int, err error) {
+	var port string
+	if host, port, err = net.SplitHostPort(addr); err != nil {
+		return
+	}
+	parts := strings.SplitN(port, "-", 2)
+	if from, err = strconv.Atoi(parts[0]); err != nil || from < 1 || from > 65535 {
+		return "", 0, 0, fmt.Errorf("bad port %q", parts[0])
+	}
+	to = from
+	if len(parts) == 2 {
+		if to, err = strconv.Atoi(parts[1]); err != nil || to < from || to > 65535 {
+			return "", 0, 0, fmt.Errorf("bad port range %q", port)
+		}
+	}
+	return
+}
+
+// Add binds the addr to the service of AP and returns the bound address. If
+// addr is a port range (`HOST:FROM-TO`), allocates the first port that is not
+// bound and is not in use.
+func (s *PublicPorts) Add(ap, service, addr string, allow IPNets) (bound string, err error) {
+	host, from, to, err := parsePortRange(addr)
+	if err != nil {
+		return "", fmt.Errorf("bad address %q: %v", addr, err)
+	}
+
+	for port := from; port <= to; port++ {
+		bound = net.JoinHostPort(host, strconv.Itoa(port))
+		var exists bool
+		if err = s.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM public_ports WHERE addr = ?)", bound).Scan(&exists); err != nil {
+			return "", fmt.Errorf("DB Query failed: %v", err)
+		}
+		if exists {
+			continue
+		}
+		if from != to {
+			var ln net.Listener
+			if ln, err = net.Listen("tcp", bound); err != nil {
+				continue
+			}
+			ln.Close()
+		}
+		if _, err = s.DB.Exec("INSERT INTO public_ports (addr, ap, service, allow, created_at) VALUES (?, ?, ?, ?, ?)",
+			bound, ap, service, &allow, time.Now()); err != nil {
+			return "", fmt.Errorf("DB Exec failed: %v", err)
+		}
+		return bound, nil
+	}
+
+	if from == to {
+		return "", fmt.Errorf("address %q is already bound", bound)
+	}
+	return "", fmt.Errorf("no free port in %q", addr)
+}
+
+func (s *PublicPorts) Remove(addr ...string) (removed int64, err error) {
+	if len(addr) == 0 {
+		return
+	}
+	var (
+		args = make([]interface{}, len(addr))
+		res  sql.Result
+	)
+	for i, addr := range addr {
+		args[i] = addr
+	}
+	if res, err = s.DB.Exec("DELETE FROM public_ports WHERE addr IN (?"+strings.Repeat(",?", len(addr)-1)+")", args...); err != nil {
+		return 0, fmt.Errorf("DB Exec failed: %v", err)
+	}
+	if removed, err = res.RowsAffected(); err != nil {
+		return 0, fmt.Errorf("DB get affected rows failed: %v", err)
+	}
+	return
+}
+
+// SetAllow replaces the source networks allowed to connect to addr. The empty
+// allow accepts all sources.
+func (s *PublicPorts) SetAllow(addr string, allow IPNets) (err error) {
+	var res sql.Result
+	if res, err = s.DB.Exec("UPDATE public_ports SET allow = ? WHERE addr = ?", &allow, addr); err != nil {
+		return fmt.Errorf("DB Exec failed: %v", err)
+	}
+	if af, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("DB get affected rows failed: %v", err)
+	} else if af == 0 {
+		return fmt.Errorf("public port %q does not exists", addr)
+	}
+	return nil
+}
+
+func (s *PublicPorts) List(cb func(i int, p *PublicPort) error, filter *PublicPortsFilter) (err error) {
+	var (
+		where = []string{"1"}
+		args  = []interface{}{}
+	)
+
+	if filter != nil && filter.Ap != "" {
+		where = append(where, "ap = ?")
+		args = append(args, filter.Ap)
+	}
+
+	rows, err := s.DB.Query("SELECT addr, ap, service, allow, created_at FROM public_ports WHERE "+
+		strings.Join(where, " AND ")+" ORDER BY ap, service, addr", args...)
+	if err != nil {
+		return fmt.Errorf("DB Query failed: %v", err)
+	}
+	defer rows.Close()
+
+	for i := 1; rows.Next(); i++ {
+		var p PublicPort
+		if err = rows.Scan(&p.Addr, &p.Ap, &p.Service, &p.Allow, &p.CreatedAt); err != nil {
+			return fmt.Errorf("Scan public port %d failed: %v", i, err)
+		}
+		if err = cb(i, &p); err != nil {
+			if err == ErrStopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
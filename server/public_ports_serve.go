@@ -0,0 +1,163 @@
+package server
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/moisespsena-go/task"
+	"github.com/moisespsena-go/xssh/common"
+)
+
+// publicPortListener accepts the connections of public port.
+type publicPortListener struct {
+	net.Listener
+	port *PublicPort
+	mu   sync.Mutex
+}
+
+func (l *publicPortListener) config() *PublicPort {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.port
+}
+
+func (l *publicPortListener) setConfig(p *PublicPort) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.port = p
+}
+
+// publicPortsListeners is the running public ports by address.
+type publicPortsListeners struct {
+	listeners map[string]*publicPortListener
+	mu        sync.Mutex
+}
+
+// ReloadPublicPorts applies the public_ports table changes to the running
+// listeners without close the active connections.
+func (srv *Server) ReloadPublicPorts() (err error) {
+	var ports = map[string]*PublicPort{}
+	if err = srv.PublicPorts.List(func(i int, p *PublicPort) error {
+		ports[p.Addr] = p
+		return nil
+	}, nil); err != nil {
+		return
+	}
+
+	srv.publicPorts.mu.Lock()
+	defer srv.publicPorts.mu.Unlock()
+
+	if srv.publicPorts.listeners == nil {
+		srv.publicPorts.listeners = map[string]*publicPortListener{}
+	}
+
+	for addr, l := range srv.publicPorts.listeners {
+		if _, ok := ports[addr]; !ok {
+			l.Close()
+			delete(srv.publicPorts.listeners, addr)
+			log.Println("public port", addr, "closed")
+		}
+	}
+
+	for addr, p := range ports {
+		if l, ok := srv.publicPorts.listeners[addr]; ok {
+			l.setConfig(p)
+			continue
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Println("ERROR: listen public port", p.String(), "failed:", err.Error())
+			continue
+		}
+		l := &publicPortListener{Listener: ln, port: p}
+		srv.publicPorts.listeners[addr] = l
+		log.Println("public port", p.String(), "started")
+		go srv.acceptPublicPort(l)
+	}
+	return nil
+}
+
+func (srv *Server) closePublicPorts() {
+	srv.publicPorts.mu.Lock()
+	defer srv.publicPorts.mu.Unlock()
+	for _, l := range srv.publicPorts.listeners {
+		l.Close()
+	}
+	srv.publicPorts.listeners = nil
+}
+
+func (srv *Server) acceptPublicPort(l *publicPortListener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		go srv.servePublicPort(l, conn)
+	}
+}
+
+// servePublicPort proxies the connection to the service of AP. The load
+// balanced services are proxied by its node.
+func (srv *Server) servePublicPort(l *publicPortListener, conn net.Conn) {
+	var (
+		p    = l.config()
+		prfx = "[port " + p.Addr + " -> " + p.Ap + "/" + p.Service + "]"
+	)
+
+	if srv.ProxyProtocol {
+		pc := newProxyProtocolConn(conn)
+		if err := pc.readHeader(); err != nil {
+			log.Println(prfx, "<"+conn.RemoteAddr().String()+">", err.Error())
+			conn.Close()
+			return
+		}
+		conn = pc
+	}
+
+	remoteAddr := conn.RemoteAddr().String()
+	if len(p.Allow) > 0 && !p.Allow.ContainsAddr(remoteAddr) {
+		log.Println(prfx, "<"+remoteAddr+">", "denied")
+		conn.Close()
+		return
+	}
+
+	ln, err := srv.register.GetListener(p.Ap, p.Service)
+	if err != nil {
+		log.Println(prfx, "<"+remoteAddr+">", err.Error())
+		conn.Close()
+		return
+	}
+	if ln.node != nil {
+		ln.node.proxy(conn)
+		return
+	}
+
+	rConn, err := ln.Listener.(*ChanListener).Dial(nil, remoteAddr)
+	if err != nil {
+		log.Println(prfx, "<"+remoteAddr+">", "dial failed:", err.Error())
+		conn.Close()
+		return
+	}
+	log.Println(prfx, "<"+remoteAddr+">", "connected")
+	common.NewIOSync(
+		common.NewCopier(prfx+" <", rConn, conn, rConn.Close, conn.Close),
+		common.NewCopier(prfx+" >", conn, rConn),
+	).Sync()
+	log.Println(prfx, "<"+remoteAddr+">", "closed")
+}
+
+func (srv *Server) setupPublicPorts(appender task.Appender) (err error) {
+	if err = srv.ReloadPublicPorts(); err != nil {
+		return
+	}
+	_ = appender.AddTask(task.NewTask(func() (err error) {
+		return nil
+	}, srv.closePublicPorts))
+	return nil
+}
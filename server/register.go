@@ -1,16 +1,23 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-errors/errors"
+	"github.com/moisespsena-go/xssh/common"
 
 	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 type ContextKey int
@@ -20,11 +27,64 @@ const (
 )
 
 type ServiceListener struct {
+	Ap   string
 	Name string
 	Listener
 	Client ssh.Session
-	cl     *ClientListeners
-	node   *Node
+	// HostKey is the public key used by AP to authenticate. The AP embedded
+	// SSH server uses the same key as host key.
+	HostKey gossh.PublicKey
+	// EmbeddedSSH is true if the service is the AP embedded SSH server. See
+	// common.EmbeddedSSHRequestType.
+	EmbeddedSSH bool
+	// DialStatus is true if the AP writes the backend dial status on load
+	// balancer entry point connections. See common.DialStatusRequestType.
+	DialStatus bool
+	// Options is the service options defined by AP. Example: `*web?weight=3`.
+	Options url.Values
+	cl      *ClientListeners
+	node    *Node
+
+	proxy     *httputil.ReverseProxy
+	proxyOnce sync.Once
+}
+
+// httpProxy returns the HTTP reverse proxy of service, used by the HttpDomain
+// subdomains of the services not load balanced.
+func (sl *ServiceListener) httpProxy() *httputil.ReverseProxy {
+	sl.proxyOnce.Do(func() {
+		cl := sl.Listener.(*ChanListener)
+		sl.proxy = &httputil.ReverseProxy{
+			Director: func(r *http.Request) {
+				r.URL.Scheme, r.URL.Host = "http", r.Host
+			},
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return cl.Dial(ctx, "http-domain")
+				},
+				DisableCompression: true,
+			},
+		}
+	})
+	return sl.proxy
+}
+
+// HostKeyCallback returns the host key callback of AP SSH service. The AP
+// embedded SSH server is verified by the AP key. The other SSH servers (AP
+// service DSN) are verified by knownHosts, with the AP name as host.
+func (sl *ServiceListener) HostKeyCallback(knownHosts *common.HostKeyConfig) gossh.HostKeyCallback {
+	if sl.EmbeddedSSH && sl.HostKey != nil {
+		return gossh.FixedHostKey(sl.HostKey)
+	}
+	if knownHosts == nil {
+		return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+			return fmt.Errorf("host key of %q is unknown", sl.Name)
+		}
+	}
+	cb := knownHosts.Callback()
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		return cb(sl.Ap, nil, key)
+	}
 }
 
 func (sl *ServiceListener) Close() error {
@@ -39,6 +99,10 @@ type DefaultReversePortForwardingRegister struct {
 	mu        sync.Mutex
 	Nodes     *Nodes
 	HttpHosts *HttpHosts
+	ACL       *ServiceACL
+	// ApKnownHosts verifies the host keys of AP SSH servers that are not
+	// embedded.
+	ApKnownHosts *common.HostKeyConfig
 }
 
 func (r *DefaultReversePortForwardingRegister) Register(ctx ssh.Context, addr string, ln net.Listener) error {
@@ -96,25 +160,30 @@ func (r *DefaultReversePortForwardingRegister) Register(ctx ssh.Context, addr st
 
 	sl := &ServiceListener{
 		Listener: ln.(Listener),
+		Ap:       apName,
 		Name:     serviceName,
 		cl:       r.forwards[apName][clientKey],
 	}
 
+	if key, ok := ctx.Value("user:pubkey").(gossh.PublicKey); ok {
+		sl.HostKey = key
+	}
+	if embedded, _ := ctx.Value("ap:embedded_ssh").(bool); embedded && serviceName == common.SrvcSSH {
+		sl.EmbeddedSSH = true
+	}
+
 	if serviceName[0] == '*' {
-		sl.Name = sl.Name[1:]
-		lb := ctx.Value("load_balancer:" + serviceName[1:]).(*LoadBalancer)
+		sl.DialStatus, _ = ctx.Value("ap:dial_status").(bool)
 		var err error
+		if sl.Name, sl.Options, err = parseServiceName(sl.Name[1:]); err != nil {
+			return errors.New("AP " + apName + "at" + clientKey + ": " + err.Error())
+		}
+		lb := ctx.Value("load_balancer:" + sl.Name).(*LoadBalancer)
 		n, err = r.Nodes.Add(lb, sl)
 		if err != nil {
 			return errors.New("AP " + apName + "at" + clientKey + ": add endpoint to node failed:" + err.Error())
 		}
 
-		r.HttpHosts.GetOrRegister(*lb.HttpHost).Set(lb, n)
-
-		n.OnClose(func() {
-			r.HttpHosts.Remove(*lb.HttpHost, lb.HttpPath)
-		})
-
 		sl.node = n
 	}
 
@@ -122,6 +191,30 @@ func (r *DefaultReversePortForwardingRegister) Register(ctx ssh.Context, addr st
 	return nil
 }
 
+// Drain starts the drain of the load balancer endpoints of AP connection and
+// returns the connections count still active, without wait for them. See
+// Node.DrainEndPoint.
+func (r *DefaultReversePortForwardingRegister) Drain(ctx ssh.Context, timeout time.Duration) (active int) {
+	var listeners []*ServiceListener
+
+	r.mu.Lock()
+	if cl, ok := r.forwards[ctx.User()][ctx.RemoteAddr().String()]; ok {
+		cl.mu.Lock()
+		for _, sl := range cl.byAddr {
+			if sl.node != nil {
+				listeners = append(listeners, sl)
+			}
+		}
+		cl.mu.Unlock()
+	}
+	r.mu.Unlock()
+
+	for _, sl := range listeners {
+		active += sl.node.DrainEndPoint(sl.Addr().String(), timeout)
+	}
+	return
+}
+
 func (r *DefaultReversePortForwardingRegister) UnRegister(ctx ssh.Context, addr string) (ln net.Listener, ok bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -206,3 +299,14 @@ func (r *DefaultReversePortForwardingRegister) GetListener(apName, serviceName s
 	})
 	return lns[0], nil
 }
+
+// GetUserListener returns the service listener if user is allowed by ACL to
+// dial it.
+func (r *DefaultReversePortForwardingRegister) GetUserListener(user, apName, serviceName string, addr ...string) (ln *ServiceListener, err error) {
+	if r.ACL != nil {
+		if err = r.ACL.Check(user, apName, serviceName); err != nil {
+			return
+		}
+	}
+	return r.GetListener(apName, serviceName, addr...)
+}
@@ -3,21 +3,237 @@ package server
 import (
 	"context"
 	"errors"
+	"hash/fnv"
+	"log"
 	"net"
+	"net/url"
 	"os"
-	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
+
+	"github.com/moisespsena-go/xssh/common"
 )
 
 type NodeServiceListener struct {
 	*ServiceListener
 	key         string
+	id          string
+	Weight      int
 	connections int
-	mu          sync.Mutex
+	health      EndPointHealth
+	draining    bool
+	// proxyProtocol is true if the AP receives the client address (see
+	// common.ProxyProtocolOption).
+	proxyProtocol bool
+	mu            sync.Mutex
+}
+
+func newNodeServiceListener(ln *ServiceListener) (sl *NodeServiceListener, err error) {
+	sl = &NodeServiceListener{ServiceListener: ln, key: ln.Addr().String()}
+	if sl.Weight, err = parseWeight(ln.Options); err != nil {
+		return nil, err
+	}
+	if v := ln.Options.Get(common.ProxyProtocolOption); v != "" {
+		if err = common.ValidateProxyProtocol(v); err != nil {
+			return nil, err
+		}
+		sl.proxyProtocol = true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(sl.key))
+	sl.id = strconv.FormatUint(h.Sum64(), 36)
+	return
+}
+
+// ID returns the opaque endpoint identifier. Used by sticky sessions.
+func (sl *NodeServiceListener) ID() string {
+	return sl.id
+}
+
+// Connections returns the active connections count.
+func (sl *NodeServiceListener) Connections() int {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.connections
+}
+
+// Health returns the health state.
+func (sl *NodeServiceListener) Health() EndPointHealth {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.health
+}
+
+// Draining reports whether the endpoint is draining: receives no new
+// connections.
+func (sl *NodeServiceListener) Draining() bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.draining
+}
+
+// available reports whether the endpoint is healthy, not ejected and not
+// draining.
+func (sl *NodeServiceListener) available() bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return !sl.draining && !sl.health.Unhealthy && !time.Now().Before(sl.health.EjectedUntil)
+}
+
+// reportError registers a traffic error and returns if the endpoint was
+// ejected. If maxErrors < 1, the outlier detection is disabled.
+func (sl *NodeServiceListener) reportError(err error, maxErrors int, ejectionTime time.Duration) (ejected bool) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	h := &sl.health
+	h.LastError = err.Error()
+	h.Errors++
+	if maxErrors > 0 && h.Errors >= maxErrors {
+		h.Errors = 0
+		h.EjectedUntil = time.Now().Add(ejectionTime)
+		return true
+	}
+	return
+}
+
+// reportSuccess resets the consecutive traffic errors count.
+func (sl *NodeServiceListener) reportSuccess() {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.health.Errors = 0
+}
+
+// setHealthCheckResult updates the health state by check result and returns
+// if the endpoint was ejected or readmitted.
+func (sl *NodeServiceListener) setHealthCheckResult(err error, healthyThreshold, unhealthyThreshold int) (changed bool) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	h := &sl.health
+	h.LastCheckAt = time.Now()
+	if err != nil {
+		h.LastError = err.Error()
+		h.Passes = 0
+		h.Fails++
+		if !h.Unhealthy && h.Fails >= unhealthyThreshold {
+			h.Unhealthy = true
+			return true
+		}
+		return
+	}
+	h.LastError = ""
+	h.Fails = 0
+	h.Passes++
+	if h.Unhealthy && h.Passes >= healthyThreshold {
+		h.Unhealthy = false
+		return true
+	}
+	return
+}
+
+// resetHealth readmits the endpoint. Used when health check is disabled.
+func (sl *NodeServiceListener) resetHealth() (changed bool) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	changed = sl.health.Unhealthy
+	sl.health = EndPointHealth{}
+	return
+}
+
+func (sl *NodeServiceListener) weight() int {
+	if sl.Weight < 1 {
+		return 1
+	}
+	return sl.Weight
+}
+
+// load returns the active connections per weight.
+func (sl *NodeServiceListener) load() float64 {
+	return float64(sl.Connections()) / float64(sl.weight())
+}
+
+// acquire counts an active request on pooled connection. See release.
+func (sl *NodeServiceListener) acquire() {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.connections++
+}
+
+func (sl *NodeServiceListener) release() {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.connections--
+}
+
+// endPointDialError is the endpoint dial failure. No data was exchanged with
+// the endpoint, so the connection can be retried on another endpoint.
+type endPointDialError struct {
+	err error
+}
+
+func (err *endPointDialError) Error() string {
+	return err.err.Error()
+}
+
+// isEndPointDialError reports whether err is an endpoint dial failure.
+func isEndPointDialError(err error) bool {
+	for {
+		switch t := err.(type) {
+		case *endPointDialError:
+			return true
+		case *net.OpError:
+			err = t.Err
+		case *url.Error:
+			err = t.Err
+		default:
+			return false
+		}
+	}
+}
+
+// dial dials the endpoint. If the AP receives the client address, writes the
+// PROXY protocol v2 header of remoteAddr before the connection data. The
+// remoteAddr without IP address, like the internal dials, is sent as LOCAL
+// command. If the AP writes the backend dial status, waits for it: the
+// backend dial failure is returned as error.
+func (sl *NodeServiceListener) dial(ctx context.Context, remoteAddr string) (conn net.Conn, err error) {
+	if conn, err = sl.ServiceListener.Listener.(*ChanListener).Dial(ctx, remoteAddr); err != nil {
+		return nil, &endPointDialError{err}
+	}
+	if sl.proxyProtocol {
+		conn = newHeaderConn(conn, common.NewProxyHeader(remoteAddr, "").Format(common.ProxyProtocolV2))
+	}
+	if sl.DialStatus {
+		if err = readDialStatus(ctx, conn); err != nil {
+			conn.Close()
+			return nil, &endPointDialError{err}
+		}
+	}
+	return
+}
+
+// readDialStatus reads the backend dial status of conn until ctx is done.
+func readDialStatus(ctx context.Context, conn net.Conn) error {
+	if ctx == nil || ctx.Done() == nil {
+		return common.ReadDialStatus(conn)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- common.ReadDialStatus(conn)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		conn.Close()
+		return ctx.Err()
+	}
 }
 
 func (sl *NodeServiceListener) Dial(ctx context.Context, remoteAddr string) (conn net.Conn, err error) {
-	conn, err = sl.ServiceListener.Listener.(*ChanListener).Dial(ctx, remoteAddr)
+	conn, err = sl.dial(ctx, remoteAddr)
 	if err == nil {
 		sl.mu.Lock()
 		defer sl.mu.Unlock()
@@ -53,17 +269,51 @@ func (n *conWithClosers) Close() error {
 }
 
 type Nodes struct {
-	Dir      string
-	data     map[string]map[string]*Node
-	Ln       net.Listener
-	SockPerm os.FileMode
-	mu       sync.RWMutex
+	Dir       string
+	data      map[string]map[string]*Node
+	Ln        net.Listener
+	SockPerm  os.FileMode
+	HttpHosts *HttpHosts
+	SNIHosts  *SNIHosts
+	// ProxyProtocol accepts the PROXY protocol header on public listeners.
+	ProxyProtocol bool
+	// LoadBalancers saves the endpoints state. Optional.
+	LoadBalancers *LoadBalancers
+	mu            sync.RWMutex
+}
+
+func (ns *Nodes) saveEndPoint(n *Node, sl *NodeServiceListener) {
+	if ns.LoadBalancers == nil {
+		return
+	}
+	if err := ns.LoadBalancers.SaveEndPoint(n.Ap, n.Service, sl.endPointStatus()); err != nil {
+		log.Println(n.String(), "EP{"+sl.key+"}: save state failed:", err.Error())
+	}
+}
+
+func (ns *Nodes) removeEndPoint(n *Node, sl *NodeServiceListener) {
+	if ns.LoadBalancers == nil {
+		return
+	}
+	if err := ns.LoadBalancers.RemoveEndPoint(n.Ap, n.Service, sl.key); err != nil {
+		log.Println(n.String(), "EP{"+sl.key+"}: remove state failed:", err.Error())
+	}
+}
+
+// Get returns the node of AP service or nil if not exists.
+func (ns *Nodes) Get(ap, service string) *Node {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+	return ns.data[ap][service]
 }
 
 func (ns *Nodes) Count(ap, service string) int {
 	ns.mu.RLock()
 	defer ns.mu.RUnlock()
+	return ns.count(ap, service)
+}
 
+func (ns *Nodes) count(ap, service string) int {
 	if ns.data == nil || ns.data[ap] == nil || ns.data[ap][service] == nil {
 		return 0
 	}
@@ -71,12 +321,24 @@ func (ns *Nodes) Count(ap, service string) int {
 }
 
 func (ns *Nodes) Add(LB *LoadBalancer, ln *ServiceListener) (node *Node, err error) {
+	if node, err = ns.add(LB, ln); err != nil {
+		return
+	}
+	node.Reload(LB)
+	return
+}
+
+func (ns *Nodes) add(LB *LoadBalancer, ln *ServiceListener) (node *Node, err error) {
 	ns.mu.Lock()
 	defer ns.mu.Unlock()
 
-	if ns.Count(LB.Ap, LB.Service) >= LB.MaxCount {
+	if ns.count(LB.Ap, LB.Service) >= LB.MaxCount {
 		return nil, errors.New("load balancer endpoints overflowing")
 	}
+	var sl *NodeServiceListener
+	if sl, err = newNodeServiceListener(ln); err != nil {
+		return
+	}
 	if ns.data == nil {
 		ns.data = map[string]map[string]*Node{}
 	}
@@ -89,13 +351,6 @@ func (ns *Nodes) Add(LB *LoadBalancer, ln *ServiceListener) (node *Node, err err
 	)
 
 	if n, ok = ns.data[LB.Ap][LB.Service]; !ok {
-		var publicAddr string
-		if LB.PublicAddr == nil || *LB.PublicAddr == "" {
-			publicAddr = ""
-		} else {
-			publicAddr = *LB.PublicAddr
-		}
-
 		n = &Node{
 			ChanListener: NewChanListener(LB.Ap + "/" + LB.Service),
 			nodes:        ns,
@@ -104,60 +359,71 @@ func (ns *Nodes) Add(LB *LoadBalancer, ln *ServiceListener) (node *Node, err err
 			Service:      LB.Service,
 			EndPoints:    map[string]*NodeServiceListener{},
 		}
-		if LB.UnixSocket {
-			ul := &UnixListener{
-				SocketPath: filepath.Join(ns.Dir, LB.Ap, LB.Service+".sock"),
-				SockPerm:   ns.SockPerm,
-			}
-			ul.Str = n.String() + "@" + ul.SocketPath
-			n.Listeners = append(n.Listeners, ul)
-		}
-		if publicAddr != "" {
-			pl := &AddrListener{
-				AddrS: publicAddr,
-			}
-			pl.StrPrefix = n.String() + "@"
-			n.Listeners = append(n.Listeners, pl)
-		}
-
-		ns.data[LB.Ap][LB.Service] = n
+		n.balancer, _ = NewBalancer(DefaultBalancer)
 		if err = n.Listen(); err != nil {
 			return
 		}
+		ns.data[LB.Ap][LB.Service] = n
 		go n.Forever()
 	}
-	key := ln.Addr().String()
-	ns.data[LB.Ap][LB.Service].EndPoints[key] = &NodeServiceListener{ServiceListener: ln, key: key}
+	n.EndPoints[sl.key] = sl
 	ln.node = n
+	ns.saveEndPoint(n, sl)
 	return n, nil
 }
 
+// Reload applies the current configuration to the running nodes. get returns
+// the load balancer of node, or nil if it was removed. The node of removed
+// load balancer keeps only the endpoints: their public listeners are closed
+// and HTTP route is unmounted.
+func (ns *Nodes) Reload(get func(ap, service string) *LoadBalancer) {
+	var nodes []*Node
+	ns.mu.RLock()
+	for _, services := range ns.data {
+		for _, n := range services {
+			nodes = append(nodes, n)
+		}
+	}
+	ns.mu.RUnlock()
+
+	for _, n := range nodes {
+		lb := get(n.Ap, n.Service)
+		if lb == nil {
+			lb = &LoadBalancer{Ap: n.Ap, Service: n.Service}
+		}
+		n.Reload(lb)
+	}
+}
 func (ns *Nodes) Remove(LB *LoadBalancer, ln *NodeServiceListener) {
 	ap, service := LB.Ap, LB.Service
 
-	if !func() bool {
-		ns.mu.RLock()
-		defer ns.mu.RUnlock()
-
-		if ns.data == nil || ns.data[ap] == nil || ns.data[ap][service] == nil || ns.data[ap][service].EndPoints[ln.key] == nil {
-			return false
+	// the idle HTTP connections of removed endpoint are closed after unlock
+	var idleNode *Node
+	defer func() {
+		if idleNode != nil {
+			idleNode.closeIdleConnections()
 		}
-		return true
-	}() {
-		return
-	}
+	}()
 
 	ns.mu.Lock()
 	defer ns.mu.Unlock()
 
-	delete(ns.data[ap][service].EndPoints, ln.key)
+	if ns.data == nil || ns.data[ap] == nil || ns.data[ap][service] == nil || ns.data[ap][service].EndPoints[ln.key] == nil {
+		return
+	}
+
+	n := ns.data[ap][service]
+	delete(n.EndPoints, ln.key)
+	ns.removeEndPoint(n, ln)
 
-	if len(ns.data[ap][service].EndPoints) == 0 {
-		ns.data[ap][service].Close()
+	if len(n.EndPoints) == 0 {
+		n.Close()
 		delete(ns.data[ap], service)
 
 		if len(ns.data[ap]) == 0 {
 			delete(ns.data, ap)
 		}
+	} else {
+		idleNode = n
 	}
 }
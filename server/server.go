@@ -6,7 +6,10 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/robfig/cron"
@@ -16,11 +19,15 @@ import (
 	"github.com/moisespsena-go/httpu"
 	"github.com/moisespsena-go/task"
 
+	"github.com/moisespsena-go/xssh/common"
 	"github.com/moisespsena-go/xssh/server/updater"
 
 	"github.com/gliderlabs/ssh"
 )
 
+// DefaultMaxDrainTimeout is the default value of Server.MaxDrainTimeout.
+const DefaultMaxDrainTimeout = 5 * time.Minute
+
 type Server struct {
 	KeyFile        string
 	Addr           string
@@ -32,15 +39,64 @@ type Server struct {
 	Cron               *cron.Cron
 	RenewTokenSchedule cron.Schedule
 
+	// LoadBalancersReloadInterval is the interval to apply the load_balancers
+	// table changes to the running nodes. The SIGHUP signal reloads it
+	// immediately. If zero, the changes only take effect when new AP
+	// registers.
+	LoadBalancersReloadInterval time.Duration
+
+	// HttpDomain routes the `<service>.<ap>.<HttpDomain>` HTTP hosts to the
+	// service registered by AP (its load balancer, if load balanced), without
+	// a load_balancers row. The ServiceACL rules of AP apply. Optional.
+	HttpDomain string
+
+	// Https is the HTTPS server config. Optional.
+	Https *HttpsConfig
+
+	// TLSPassthroughAddr is the listen address of TLS connections routed by
+	// server name (SNI) to the load balancers, without decrypt them. See
+	// LoadBalancer.TLSSNIHost. Optional.
+	TLSPassthroughAddr string
+
+	// ProxyProtocol accepts the PROXY protocol v1 and v2 header on load
+	// balancers public listeners and on TLS passthrough listener, when the
+	// server is behind a proxy (HAProxy or cloud load balancers).
+	ProxyProtocol bool
+
+	// ApKnownHostsFile is the known_hosts file of AP SSH servers that are not
+	// the AP embedded SSH server. Unknown hosts are trusted on first use. If
+	// blank, uses `ap_known_hosts`.
+	ApKnownHostsFile string
+
+	// TrustedProxies is the proxies networks whose X-Forwarded-For header is
+	// used as HTTP client address by the load balancers source networks.
+	TrustedProxies IPNets
+
+	// MaxDrainTimeout is the max drain timeout requested by AP. If zero, uses
+	// DefaultMaxDrainTimeout.
+	MaxDrainTimeout time.Duration
+
 	Users         *Users
+	CertAuthority *CertAuthority
+	ServiceACL    *ServiceACL
 	LoadBalancers *LoadBalancers
-	register      *DefaultReversePortForwardingRegister
-	HttpHosts     *HttpHosts
+	// TLSCertificates is the store of manual HTTPS certificates. Optional.
+	TLSCertificates *TLSCertificates
+	// PublicPorts is the store of server TCP ports bound to AP services.
+	// Optional.
+	PublicPorts *PublicPorts
+	register    *DefaultReversePortForwardingRegister
+	HttpHosts   *HttpHosts
+	SNIHosts    *SNIHosts
 
 	srv        *ssh.Server
 	ln         net.Listener
 	running    bool
 	httpServer *httpu.Server
+
+	httpsServer *http.Server
+	certs       *certSelector
+	publicPorts publicPortsListeners
 }
 
 func (srv *Server) CreateToken() (err error) {
@@ -76,16 +132,35 @@ func (srv *Server) Setup(appender task.Appender) (err error) {
 		}
 	}
 
+	if srv.ApKnownHostsFile == "" {
+		srv.ApKnownHostsFile = "ap_known_hosts"
+	}
+
 	if srv.HttpHosts == nil {
 		srv.HttpHosts = &HttpHosts{}
 	}
+	if srv.SNIHosts == nil {
+		srv.SNIHosts = &SNIHosts{}
+	}
 
 	srv.register = &DefaultReversePortForwardingRegister{
 		Nodes: &Nodes{
-			Dir:      srv.SocketsDir,
-			SockPerm: srv.NodeSockerPerm,
+			Dir:           srv.SocketsDir,
+			SockPerm:      srv.NodeSockerPerm,
+			HttpHosts:     srv.HttpHosts,
+			SNIHosts:      srv.SNIHosts,
+			ProxyProtocol: srv.ProxyProtocol,
+			LoadBalancers: srv.LoadBalancers,
 		},
-		HttpHosts: srv.HttpHosts,
+		HttpHosts:    srv.HttpHosts,
+		ACL:          srv.ServiceACL,
+		ApKnownHosts: &common.HostKeyConfig{KnownHostsFile: srv.ApKnownHostsFile},
+	}
+
+	if srv.LoadBalancers != nil {
+		if err = srv.LoadBalancers.ClearEndPoints(); err != nil {
+			return fmt.Errorf("clear load balancer endpoints failed: %v", err)
+		}
 	}
 
 	if err := os.RemoveAll(srv.SocketsDir); err != nil {
@@ -105,6 +180,24 @@ func (srv *Server) Setup(appender task.Appender) (err error) {
 		appender.AddTask(srv.httpServer)
 	}
 
+	if srv.Https != nil {
+		if err = srv.setupHttps(appender); err != nil {
+			return fmt.Errorf("setup https server failed: %v", err)
+		}
+	}
+
+	if srv.TLSPassthroughAddr != "" {
+		if err = srv.setupTLSPassthrough(appender); err != nil {
+			return fmt.Errorf("setup tls passthrough server failed: %v", err)
+		}
+	}
+
+	if srv.PublicPorts != nil {
+		if err = srv.setupPublicPorts(appender); err != nil {
+			return fmt.Errorf("setup public ports failed: %v", err)
+		}
+	}
+
 	if srv.RenewTokenSchedule != nil {
 		if srv.Cron == nil {
 			srv.Cron = cron.New()
@@ -122,10 +215,70 @@ func (srv *Server) Setup(appender task.Appender) (err error) {
 		}))
 	}
 
+	if srv.LoadBalancersReloadInterval > 0 {
+		var stop = make(chan struct{})
+		_ = appender.AddTask(task.NewTask(func() (err error) {
+			go srv.reloadLoadBalancersForever(stop)
+			return nil
+		}, func() {
+			close(stop)
+		}))
+	}
+
 	srv.setupSshServer()
 	return nil
 }
 
+// ReloadLoadBalancers applies the load_balancers table changes to the running
+// nodes without close the active connections and reloads the manual HTTPS
+// certificates and the public ports.
+func (srv *Server) ReloadLoadBalancers() (err error) {
+	var lbs = map[string]*LoadBalancer{}
+	if err = srv.LoadBalancers.List(func(i int, lb *LoadBalancer) error {
+		lbs[lb.Ap+"/"+lb.Service] = lb
+		return nil
+	}, nil); err != nil {
+		return
+	}
+	srv.register.Nodes.Reload(func(ap, service string) *LoadBalancer {
+		return lbs[ap+"/"+service]
+	})
+	if srv.PublicPorts != nil {
+		if err = srv.ReloadPublicPorts(); err != nil {
+			return
+		}
+	}
+	if srv.certs != nil {
+		return srv.certs.Reload()
+	}
+	return nil
+}
+
+func (srv *Server) reloadLoadBalancersForever(stop chan struct{}) {
+	var (
+		ticker = time.NewTicker(srv.LoadBalancersReloadInterval)
+		hup    = make(chan os.Signal, 1)
+	)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer func() {
+		signal.Stop(hup)
+		ticker.Stop()
+	}()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			log.Println("SIGHUP received: reloading load balancers")
+		case <-ticker.C:
+		}
+		if err := srv.ReloadLoadBalancers(); err != nil {
+			log.Println("ERROR: reload load balancers failed:", err.Error())
+		}
+	}
+}
+
 func (srv *Server) Run() (err error) {
 	srv.running = true
 	defer func() {
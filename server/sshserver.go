@@ -1,12 +1,15 @@
 package server
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gliderlabs/ssh"
 	"github.com/moisespsena-go/xssh/common"
@@ -29,7 +32,15 @@ func (srv *Server) setupSshServer() {
 				return
 			}
 
+			if isEnrolling(s.Context()) && args[0] != "enroll" {
+				s.Stderr().Write([]byte("key enrollment is pending: only `enroll` command is allowed\n"))
+				s.Exit(1)
+				return
+			}
+
 			switch args[0] {
+			case "enroll":
+				srv.enroll(s)
 			case "update":
 				if srv.Updater == nil {
 					var r common.UpgradePayload
@@ -73,10 +84,13 @@ func (srv *Server) setupSshServer() {
 				baseName = ctx.Value(ssh.ContextKeyRemoteAddr).(net.Addr).String()
 			)
 			if name[0] == '*' {
-				serviceName := name[1:]
+				var serviceName string
+				if serviceName, _, err = parseServiceName(name[1:]); err != nil {
+					return
+				}
 				var b *LoadBalancer
 				if b, err = srv.LoadBalancers.Get(ap, serviceName); err != nil {
-					err = fmt.Errorf("LoadBalancers.Get(%q, %q) failed: %v", ap, serviceName)
+					err = fmt.Errorf("LoadBalancers.Get(%q, %q) failed: %v", ap, serviceName, err)
 					return
 				} else if b == nil {
 					return nil, fmt.Errorf("Load Balance of AP %q and service %q not registered", ap, serviceName)
@@ -102,7 +116,15 @@ func (srv *Server) setupSshServer() {
 			return (strings.HasPrefix(addr, "unix:") || strings.HasPrefix(addr, "virtual:")) && ctx.Value("is:ap").(bool)
 		},
 		SocketForwardingCallback: func(ctx ssh.Context, addr string) bool {
-			return !ctx.Value("is:ap").(bool)
+			if ctx.Value("is:ap").(bool) {
+				return false
+			}
+			name := strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "virtual:")
+			if err := srv.checkAccess(ctx, name); err != nil {
+				log.Printf("[CL %s] {%s} dial denied: %v", ctx.User(), name, err)
+				return false
+			}
+			return true
 		},
 		ConnCallback: func(conn net.Conn) net.Conn {
 			var i interface{} = conn
@@ -116,6 +138,44 @@ func (srv *Server) setupSshServer() {
 	srv.srv.RequestHandler("", ssh.RequestHandlerFunc(func(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (ok bool, payload []byte) {
 		return true, nil
 	}))
+	srv.srv.RequestHandler("service-access", ssh.RequestHandlerFunc(func(ctx ssh.Context, _ *ssh.Server, req *gossh.Request) (ok bool, payload []byte) {
+		if ctx.Value("is:ap").(bool) {
+			return false, []byte("access points can't dial services")
+		}
+		if err := srv.checkAccess(ctx, string(req.Payload)); err != nil {
+			return false, []byte(err.Error())
+		}
+		return true, nil
+	}))
+	srv.srv.RequestHandler(common.DrainRequestType, ssh.RequestHandlerFunc(func(ctx ssh.Context, _ *ssh.Server, req *gossh.Request) (ok bool, payload []byte) {
+		if !ctx.Value("is:ap").(bool) {
+			return false, []byte("only access points can drain")
+		}
+		var dr common.DrainRequest
+		if err := gossh.Unmarshal(req.Payload, &dr); err != nil {
+			return false, []byte("bad drain request: " + err.Error())
+		}
+		timeout := time.Duration(dr.Timeout) * time.Second
+		if max := srv.maxDrainTimeout(); timeout > max {
+			timeout = max
+		}
+		active := register.Drain(ctx, timeout)
+		return true, gossh.Marshal(&common.DrainReply{Active: uint32(active)})
+	}))
+	srv.srv.RequestHandler(common.EmbeddedSSHRequestType, ssh.RequestHandlerFunc(func(ctx ssh.Context, _ *ssh.Server, req *gossh.Request) (ok bool, payload []byte) {
+		if !ctx.Value("is:ap").(bool) {
+			return false, nil
+		}
+		ctx.SetValue("ap:embedded_ssh", true)
+		return true, nil
+	}))
+	srv.srv.RequestHandler(common.DialStatusRequestType, ssh.RequestHandlerFunc(func(ctx ssh.Context, _ *ssh.Server, req *gossh.Request) (ok bool, payload []byte) {
+		if !ctx.Value("is:ap").(bool) {
+			return false, nil
+		}
+		ctx.SetValue("ap:dial_status", true)
+		return true, []byte(common.DialStatusRequestType)
+	}))
 	srv.srv.RequestHandler("ap-version", ssh.RequestHandlerFunc(func(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (ok bool, payload []byte) {
 		var v common.Version
 		log.Println("[AP " + ctx.User() + "] version=" + fmt.Sprint(*v.Unmarshal(req.Payload)))
@@ -156,15 +216,130 @@ func (srv *Server) setupSshServer() {
 			log.Printf("User is blank\n")
 			return false
 		}
-		err, ok, isAp := srv.Users.CheckUser(user, string(gossh.MarshalAuthorizedKey(key)))
+		var (
+			err      error
+			ok, isAp bool
+			keyName  string
+		)
+		if cert, isCert := key.(*gossh.Certificate); isCert && srv.CertAuthority != nil {
+			if isAp, err = srv.CertAuthority.Check(user, ctx.RemoteAddr(), cert); err == nil {
+				ok = true
+				keyName = fmt.Sprintf("cert:%s#%d", cert.KeyId, cert.Serial)
+			}
+		} else {
+			err, ok, isAp, keyName = srv.Users.CheckUser(user, string(gossh.MarshalAuthorizedKey(key)))
+		}
 		if err != nil {
 			log.Println("ERROR:", err)
 			return false
 		}
+		if !ok {
+			if _, isCert := key.(*gossh.Certificate); !isCert {
+				if enrolling, err := srv.Users.HasEnrollment(user); err != nil {
+					log.Println("ERROR:", err)
+				} else if enrolling {
+					log.Printf("User %q authenticated for enrollment only\n", user)
+					ctx.SetValue("user:pubkey", gossh.PublicKey(key))
+					ctx.SetValue("user:name", user)
+					ctx.SetValue("is:enrolling", true)
+					ctx.SetValue("is:ap", false)
+					ctx.SetValue("is:proxy", false)
+					return true
+				}
+			}
+			return false
+		}
 		if ok {
+			if !isAp && apName != "" {
+				if granted, err := srv.Users.HasAp(user, apName); err != nil {
+					log.Println("ERROR:", err)
+					return false
+				} else if !granted {
+					log.Printf("User %q does not have access to AP %q\n", user, apName)
+					return false
+				}
+			}
+			if keyName != "" {
+				log.Printf("User %q authenticated with key %q\n", user, keyName)
+				ctx.SetValue("user:key", keyName)
+			}
+			var pubKey gossh.PublicKey = key
+			if cert, isCert := key.(*gossh.Certificate); isCert {
+				pubKey = cert.Key
+			}
+			ctx.SetValue("user:pubkey", pubKey)
+			ctx.SetValue("user:name", user)
 			ctx.SetValue("is:ap", isAp)
 			ctx.SetValue("is:proxy", proxy)
 		}
 		return ok
 	}))
 }
+
+// maxDrainTimeout returns MaxDrainTimeout or DefaultMaxDrainTimeout.
+func (srv *Server) maxDrainTimeout() time.Duration {
+	if srv.MaxDrainTimeout > 0 {
+		return srv.MaxDrainTimeout
+	}
+	return DefaultMaxDrainTimeout
+}
+
+// checkAccess returns error if the authenticated client user has not been
+// granted access to the AP named in the `user:ap` DSN or to the service of it.
+// If service is blank, only the AP grant is checked.
+func (srv *Server) checkAccess(ctx ssh.Context, service string) error {
+	if isEnrolling(ctx) {
+		return fmt.Errorf("user %q: key enrollment is pending", ctx.User())
+	}
+	user, _ := ctx.Value("user:name").(string)
+	apName, _ := ctx.Value("ap:name").(string)
+	if user == "" || apName == "" {
+		return fmt.Errorf("user %q: AP name is blank", ctx.User())
+	}
+	if ok, err := srv.Users.HasAp(user, apName); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("user %q does not have access to AP %q", user, apName)
+	}
+	if service != "" && srv.ServiceACL != nil {
+		return srv.ServiceACL.Check(user, apName, service)
+	}
+	return nil
+}
+
+// isEnrolling reports whether the client was authenticated with an unknown key
+// only to consume an enrollment token.
+func isEnrolling(ctx context.Context) bool {
+	v, _ := ctx.Value("is:enrolling").(bool)
+	return v
+}
+
+// enroll reads the enrollment token from session and binds the client key to
+// the user.
+func (srv *Server) enroll(s ssh.Session) {
+	ctx := s.Context()
+	if !isEnrolling(ctx) {
+		io.WriteString(s, "key already enrolled\n")
+		return
+	}
+
+	user := ctx.Value("user:name").(string)
+	token, err := bufio.NewReader(io.LimitReader(s, 1024)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Printf("[%s] read enrollment token failed: %v", user, err)
+		s.Exit(1)
+		return
+	}
+
+	key := string(gossh.MarshalAuthorizedKey(ctx.Value("user:pubkey").(gossh.PublicKey)))
+	keyName, err := srv.Users.ConsumeEnrollment(user, token, key, s.RemoteAddr().String())
+	if err != nil {
+		log.Printf("[%s] enrollment from %v failed: %v", user, s.RemoteAddr(), err)
+		io.WriteString(s.Stderr(), "enrollment failed: "+err.Error()+"\n")
+		s.Exit(1)
+		return
+	}
+
+	log.Printf("[%s] key enrolled as %q from %v", user, keyName, s.RemoteAddr())
+	io.WriteString(s, fmt.Sprintf("key enrolled as %q\n", keyName))
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSCertificate is the manual HTTPS certificate of host.
+type TLSCertificate struct {
+	// Host is the server name. Accepts wildcard hosts (`*.example.com`).
+	Host      string    `json:"host"`
+	Cert      string    `json:"-"`
+	Key       string    `json:"-"`
+	NotAfter  time.Time `json:"not_after"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c TLSCertificate) String() string {
+	s := c.Host + " expires " + c.NotAfter.Format(time.RFC3339)
+	if !c.NotAfter.After(time.Now()) {
+		s += " [EXPIRED]"
+	}
+	return s
+}
+
+// TLSCertificates stores the manual HTTPS certificates per host and the ACME
+// cache.
+type TLSCertificates struct {
+	DB *DB
+}
+
+func NewTLSCertificates(db *DB) *TLSCertificates {
+	return &TLSCertificates{DB: db}
+}
+
+// parseKeyPair parses the PEM encoded certificate chain and key.
+func parseKeyPair(certPEM, keyPEM []byte) (cert *tls.Certificate, err error) {
+	var c tls.Certificate
+	if c, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return
+	}
+	if c.Leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil {
+		return
+	}
+	return &c, nil
+}
+
+// Add adds or replaces the certificate of host.
+func (s *TLSCertificates) Add(host string, certPEM, keyPEM []byte) (err error) {
+	var cert *tls.Certificate
+	if cert, err = parseKeyPair(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("bad certificate: %v", err)
+	}
+	host = strings.ToLower(host)
+	if err = cert.Leaf.VerifyHostname(strings.Replace(host, "*", "wildcard", 1)); err != nil {
+		return fmt.Errorf("certificate is not valid for host %q: %v", host, err)
+	}
+	_, err = s.DB.Exec("INSERT OR REPLACE INTO tls_certificates (host, cert, key, not_after, created_at) VALUES (?, ?, ?, ?, ?)",
+		host, string(certPEM), string(keyPEM), cert.Leaf.NotAfter, time.Now())
+	if err != nil {
+		return fmt.Errorf("DB Exec failed: %v", err)
+	}
+	return nil
+}
+
+func (s *TLSCertificates) Remove(host ...string) (removed int64, err error) {
+	if len(host) == 0 {
+		return
+	}
+	var (
+		args = make([]interface{}, len(host))
+		res  sql.Result
+	)
+	for i, host := range host {
+		args[i] = strings.ToLower(host)
+	}
+	if res, err = s.DB.Exec("DELETE FROM tls_certificates WHERE host IN (?"+strings.Repeat(",?", len(host)-1)+")", args...); err != nil {
+		return 0, fmt.Errorf("DB Exec failed: %v", err)
+	}
+	if removed, err = res.RowsAffected(); err != nil {
+		return 0, fmt.Errorf("DB get affected rows failed: %v", err)
+	}
+	return
+}
+
+func (s *TLSCertificates) List(cb func(i int, c *TLSCertificate) error) (err error) {
+	rows, err := s.DB.Query("SELECT host, cert, key, not_after, created_at FROM tls_certificates ORDER BY host")
+	if err != nil {
+		return fmt.Errorf("DB Query failed: %v", err)
+	}
+	defer rows.Close()
+
+	for i := 1; rows.Next(); i++ {
+		var c TLSCertificate
+		if err = rows.Scan(&c.Host, &c.Cert, &c.Key, &c.NotAfter, &c.CreatedAt); err != nil {
+			return fmt.Errorf("Scan TLS certificate %d failed: %v", i, err)
+		}
+		if err = cb(i, &c); err != nil {
+			if err == ErrStopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// ACMECache returns the autocert cache of ACME account and certificates.
+func (s *TLSCertificates) ACMECache() autocert.Cache {
+	return acmeCache{s.DB}
+}
+
+// acmeCache is the autocert.Cache implementation stored on acme_cache table.
+type acmeCache struct {
+	DB *DB
+}
+
+func (c acmeCache) Get(ctx context.Context, key string) (data []byte, err error) {
+	if err = c.DB.QueryRowContext(ctx, "SELECT data FROM acme_cache WHERE key = ?", key).Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, autocert.ErrCacheMiss
+		}
+		return nil, fmt.Errorf("DB Query failed: %v", err)
+	}
+	return
+}
+
+func (c acmeCache) Put(ctx context.Context, key string, data []byte) (err error) {
+	if _, err = c.DB.ExecContext(ctx, "INSERT OR REPLACE INTO acme_cache (key, data, updated_at) VALUES (?, ?, ?)",
+		key, data, time.Now()); err != nil {
+		return fmt.Errorf("DB Exec failed: %v", err)
+	}
+	return nil
+}
+
+func (c acmeCache) Delete(ctx context.Context, key string) (err error) {
+	if _, err = c.DB.ExecContext(ctx, "DELETE FROM acme_cache WHERE key = ?", key); err != nil {
+		return fmt.Errorf("DB Exec failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moisespsena-go/task"
+)
+
+// ClientHelloTimeout is the max time to read the ClientHello of TLS
+// passthrough connections.
+var ClientHelloTimeout = 10 * time.Second
+
+var errClientHelloRead = errors.New("client hello read")
+
+// SNIHosts is the TLS passthrough routes: the node of server name.
+type SNIHosts struct {
+	hosts map[string]*Node
+	mu    sync.RWMutex
+}
+
+func (h *SNIHosts) Set(host string, n *Node) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hosts == nil {
+		h.hosts = map[string]*Node{}
+	}
+	if old := h.hosts[host]; old != nil && old != n {
+		log.Println(n.String(), "TLS SNI host `"+host+"` replaces", old.String())
+	}
+	h.hosts[host] = n
+}
+
+// Unset removes the route of host if it is of node n.
+func (h *SNIHosts) Unset(host string, n *Node) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hosts[host] == n {
+		delete(h.hosts, host)
+	}
+}
+
+// Match returns the node of server name. The exact host is matched first and
+// then the wildcard hosts, from the most specific to the least specific.
+func (h *SNIHosts) Match(serverName string) *Node {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var (
+		host = strings.ToLower(strings.TrimSuffix(serverName, "."))
+		name = host
+	)
+	for host != "" {
+		if n := h.hosts[name]; n != nil {
+			return n
+		}
+		pos := strings.IndexByte(host, '.')
+		if pos == -1 {
+			return nil
+		}
+		host = host[pos+1:]
+		name = "*." + host
+	}
+	return nil
+}
+
+// helloConn reads the ClientHello from r and does not write.
+type helloConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c helloConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (helloConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// peekedConn replays the peeked bytes before read from connection.
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// peekServerName reads the server name of TLS ClientHello. Returns the
+// connection that replays the read bytes.
+func peekServerName(conn net.Conn) (serverName string, peeked net.Conn, err error) {
+	var (
+		buf   bytes.Buffer
+		hello *tls.ClientHelloInfo
+	)
+	conn.SetReadDeadline(time.Now().Add(ClientHelloTimeout))
+	err = tls.Server(helloConn{conn, io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = info
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+	conn.SetReadDeadline(time.Time{})
+	if hello == nil {
+		return "", nil, err
+	}
+	return hello.ServerName, &peekedConn{conn, io.MultiReader(&buf, conn)}, nil
+}
+
+// serveTLSPassthrough routes the TLS connection to the node of its server name,
+// without decrypt it.
+func (srv *Server) serveTLSPassthrough(conn net.Conn) {
+	serverName, peeked, err := peekServerName(conn)
+	if err != nil {
+		log.Println("TLS:", "<"+conn.RemoteAddr().String()+">", "read client hello failed:", err.Error())
+		conn.Close()
+		return
+	}
+	n := srv.SNIHosts.Match(serverName)
+	if n == nil {
+		log.Println("TLS:", "<"+conn.RemoteAddr().String()+">", "no route for server name `"+serverName+"`")
+		conn.Close()
+		return
+	}
+	if !n.allowSource(n.loadBalancer(), conn.RemoteAddr().String()) {
+		conn.Close()
+		return
+	}
+	n.proxy(peeked)
+}
+
+func (srv *Server) setupTLSPassthrough(appender task.Appender) (err error) {
+	var ln net.Listener
+	if ln, err = net.Listen("tcp", srv.TLSPassthroughAddr); err != nil {
+		return
+	}
+	log.Printf("starting tls passthrough server on %v", ln.Addr())
+
+	_ = appender.AddTask(task.NewTask(func() (err error) {
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					if ne, ok := err.(net.Error); ok && ne.Temporary() {
+						time.Sleep(50 * time.Millisecond)
+						continue
+					}
+					return
+				}
+				if srv.ProxyProtocol {
+					conn = newProxyProtocolConn(conn)
+				}
+				go srv.serveTLSPassthrough(conn)
+			}
+		}()
+		return nil
+	}, func() {
+		ln.Close()
+	}))
+	return nil
+}
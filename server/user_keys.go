@@ -0,0 +1,168 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type UserKey struct {
+	User, Name  string
+	PubKey      string
+	ExpiresAt   *time.Time
+	Revoked     bool
+	CreatedAt   time.Time
+	LastUsedAt  *time.Time
+	Fingerprint string
+}
+
+func (k UserKey) Expired() bool {
+	return k.ExpiresAt != nil && !k.ExpiresAt.After(time.Now())
+}
+
+func (k UserKey) String() (s string) {
+	s += k.Name + " " + k.Fingerprint
+
+	var flags []string
+
+	if k.Revoked {
+		flags = append(flags, "REVOKED")
+	}
+
+	if k.Expired() {
+		flags = append(flags, "EXPIRED")
+	} else if k.ExpiresAt != nil {
+		flags = append(flags, "EXPIRES="+k.ExpiresAt.Format(time.RFC3339))
+	}
+
+	if k.LastUsedAt != nil {
+		flags = append(flags, "LAST_USED="+k.LastUsedAt.Format(time.RFC3339))
+	}
+
+	if len(flags) > 0 {
+		s += " [" + strings.Join(flags, ",") + "]"
+	}
+
+	return s
+}
+
+// KeyAdd adds the named public key to user. The pubKey is in authorized keys
+// format.
+func (s *Users) KeyAdd(user, name, pubKey string, expiresAt *time.Time) (err error) {
+	key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(pubKey))
+	if err != nil {
+		return fmt.Errorf("parse public key failed: %v", err)
+	}
+	_, err = s.DB.Exec("INSERT INTO user_keys (user, name, pub_key, expires_at) VALUES (?, ?, ?, ?)",
+		user, name, string(gossh.MarshalAuthorizedKey(key)), expiresAt)
+	if err != nil {
+		return fmt.Errorf("DB Exec failed: %v", err)
+	}
+	return nil
+}
+
+func (s *Users) KeyRevoke(user string, name ...string) (revoked int64, err error) {
+	if len(name) == 0 {
+		return
+	}
+
+	sqls := "UPDATE user_keys SET revoked = true WHERE user = ? AND name IN "
+	sqls += "(?" + strings.Repeat(",?", len(name)-1) + ")"
+
+	var args = []interface{}{user}
+	for _, name := range name {
+		args = append(args, name)
+	}
+
+	if result, err := s.DB.Exec(sqls, args...); err != nil {
+		return 0, fmt.Errorf("DB Exec failed: %v", err)
+	} else if revoked, err = result.RowsAffected(); err != nil {
+		err = fmt.Errorf("DB Get Affcted Rows failed: %v", err)
+		return 0, err
+	}
+	return
+}
+
+func (s *Users) Keys(user string, cb func(i int, k *UserKey) error) (err error) {
+	rows, err := s.DB.Query("SELECT user, name, pub_key, expires_at, revoked, created_at, last_used_at "+
+		"FROM user_keys WHERE user = ? ORDER BY name ASC", user)
+	if err != nil {
+		return fmt.Errorf("DB Query failed: %v", err)
+	}
+
+	defer rows.Close()
+
+	for i := 1; rows.Next(); i++ {
+		var k UserKey
+		if err = rows.Scan(&k.User, &k.Name, &k.PubKey, &k.ExpiresAt, &k.Revoked, &k.CreatedAt, &k.LastUsedAt); err != nil {
+			return fmt.Errorf("Scan key %d failed: %v", i, err)
+		}
+		if key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(k.PubKey)); err == nil {
+			k.Fingerprint = gossh.FingerprintSHA256(key)
+		}
+		if err = cb(i, &k); err != nil {
+			if err == ErrStopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkKey finds key in user keys. The same key may be stored under many
+// names: a valid one is preferred. If all are revoked or expired, returns
+// error.
+func (s *Users) checkKey(user, key string) (name string, ok bool, err error) {
+	var (
+		rows   *sql.Rows
+		k      UserKey
+		reject error
+	)
+
+	func() {
+		rows, err = s.DB.Query("SELECT name, expires_at, revoked FROM user_keys WHERE user = ? AND pub_key = ? ORDER BY name", user, key)
+		if err != nil {
+			return
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			if err = rows.Scan(&k.Name, &k.ExpiresAt, &k.Revoked); err != nil {
+				return
+			}
+			if k.Revoked {
+				if reject == nil {
+					reject = fmt.Errorf("Key %q of user %q is revoked", k.Name, user)
+				}
+			} else if k.Expired() {
+				if reject == nil {
+					reject = fmt.Errorf("Key %q of user %q expired at %v", k.Name, user, k.ExpiresAt.Format(time.RFC3339))
+				}
+			} else {
+				ok = true
+				return
+			}
+		}
+		err = rows.Err()
+	}()
+
+	if err != nil {
+		return "", false, err
+	}
+
+	if !ok {
+		return "", false, reject
+	}
+
+	if _, err = s.DB.Exec("UPDATE user_keys SET last_used_at = ? WHERE user = ? AND name = ?", time.Now(), user, k.Name); err != nil {
+		return "", false, fmt.Errorf("update last use of key %q failed: %v", k.Name, err)
+	}
+
+	return k.Name, true, nil
+}
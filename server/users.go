@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -60,28 +59,49 @@ func (s *Users) Remove(name ...string) (removed int64, err error) {
 		return
 	}
 
-	sqls := "DELETE FROM users WHERE name IN "
-	sqls += "(?" + strings.Repeat(",?", len(name)-1) + ")"
-
-	var stmt *sql.Stmt
-
-	if stmt, err = s.DB.Prepare(sqls); err != nil {
-		return 0, fmt.Errorf("DB Prepare failed: %v", err)
+	var tx *sql.Tx
+	if tx, err = s.DB.Begin(); err != nil {
+		return 0, fmt.Errorf("DB Begin failed: %v", err)
 	}
 
-	defer stmt.Close()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
 
 	var namesi []interface{}
 	for _, name := range name {
 		namesi = append(namesi, name)
 	}
 
-	if result, err := stmt.Exec(namesi...); err != nil {
+	in := "(?" + strings.Repeat(",?", len(name)-1) + ")"
+
+	if result, err := tx.Exec("DELETE FROM users WHERE name IN "+in, namesi...); err != nil {
 		return 0, fmt.Errorf("DB Exec failed: %v", err)
 	} else if removed, err = result.RowsAffected(); err != nil {
 		err = fmt.Errorf("DB Get Affcted Rows failed: %v", err)
 		return 0, err
 	}
+
+	if _, err = tx.Exec("DELETE FROM user_ap WHERE user IN "+in+" OR ap IN "+in, append(namesi, namesi...)...); err != nil {
+		return 0, fmt.Errorf("DB Exec failed: %v", err)
+	}
+	// a re-created user must not inherit the service rules
+	if _, err = tx.Exec("DELETE FROM service_acl WHERE user IN "+in+" OR ap IN "+in, append(namesi, namesi...)...); err != nil {
+		return 0, fmt.Errorf("DB Exec failed: %v", err)
+	}
+	if _, err = tx.Exec("DELETE FROM user_keys WHERE user IN "+in, namesi...); err != nil {
+		return 0, fmt.Errorf("DB Exec failed: %v", err)
+	}
+	// a pending token must not enroll a key of a re-created user
+	if _, err = tx.Exec("DELETE FROM enrollment_tokens WHERE used_at IS NULL AND user IN "+in, namesi...); err != nil {
+		return 0, fmt.Errorf("DB Exec failed: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("DB Commit failed: %v", err)
+	}
 	return
 }
 
@@ -152,9 +172,13 @@ func (s *Users) List(isAp bool, cb func(i int, u *User) error, nameMatch ...stri
 	return nil
 }
 
-func (s *Users) CheckUser(user, key string) (err error, ok, isAp bool) {
+// CheckUser checks the authorized key of user. It accepts the legacy user
+// `pub_key` or any non-expired and non-revoked key of `user_keys`. If the key
+// was found in `user_keys`, keyName is the name of it. New keys are bound
+// only by enrollment tokens (see Users.Enroll).
+func (s *Users) CheckUser(user, key string) (err error, ok, isAp bool, keyName string) {
 	var (
-		updateKey bool
+		updateKey bool // deprecated: replaced by enrollment tokens
 		isAptPtr  *bool
 		pubKey    *string
 		rows      *sql.Rows
@@ -183,13 +207,8 @@ func (s *Users) CheckUser(user, key string) (err error, ok, isAp bool) {
 	}
 
 	if pubKey == nil || *pubKey != key {
-		if updateKey {
-			_, err = s.DB.Exec("update users set pub_key = ?, update_key = false where name = ?", key, user)
-			if err != nil {
-				log.Printf("update %q pub_key failed: %v", user, err)
-				return
-			}
-			ok = true
+		if keyName, ok, err = s.checkKey(user, key); err != nil {
+			return
 		}
 	} else {
 		ok = true
@@ -231,3 +250,105 @@ func (s *Users) IsAp(user string) (ok bool, err error) {
 
 	return
 }
+
+// Grant grants user access to the access points.
+func (s *Users) Grant(user string, ap ...string) (added int64, err error) {
+	if len(ap) == 0 {
+		return
+	}
+
+	if isAp, err := s.IsAp(user); err != nil {
+		return 0, err
+	} else if isAp {
+		return 0, fmt.Errorf("User %q is an access point", user)
+	}
+
+	for _, ap := range ap {
+		if isAp, err := s.IsAp(ap); err != nil {
+			return added, err
+		} else if !isAp {
+			return added, fmt.Errorf("User %q is not an access point", ap)
+		}
+	}
+
+	var stmt *sql.Stmt
+
+	if stmt, err = s.DB.Prepare("INSERT OR IGNORE INTO user_ap (user, ap) VALUES (?, ?)"); err != nil {
+		return 0, fmt.Errorf("DB Prepare failed: %v", err)
+	}
+
+	defer stmt.Close()
+
+	for _, ap := range ap {
+		if result, err := stmt.Exec(user, ap); err != nil {
+			return added, fmt.Errorf("DB Exec failed: %v", err)
+		} else if af, err := result.RowsAffected(); err != nil {
+			return added, fmt.Errorf("DB Get Affcted Rows failed: %v", err)
+		} else {
+			added += af
+		}
+	}
+	return
+}
+
+// Revoke revokes user access to the access points.
+func (s *Users) Revoke(user string, ap ...string) (removed int64, err error) {
+	if len(ap) == 0 {
+		return
+	}
+
+	sqls := "DELETE FROM user_ap WHERE user = ? AND ap IN "
+	sqls += "(?" + strings.Repeat(",?", len(ap)-1) + ")"
+
+	var args = []interface{}{user}
+	for _, ap := range ap {
+		args = append(args, ap)
+	}
+
+	if result, err := s.DB.Exec(sqls, args...); err != nil {
+		return 0, fmt.Errorf("DB Exec failed: %v", err)
+	} else if removed, err = result.RowsAffected(); err != nil {
+		err = fmt.Errorf("DB Get Affcted Rows failed: %v", err)
+		return 0, err
+	}
+	return
+}
+
+// Grants iterates over the access points granted to user.
+func (s *Users) Grants(user string, cb func(i int, ap string) error) (err error) {
+	rows, err := s.DB.Query("SELECT ap FROM user_ap WHERE user = ? ORDER BY ap ASC", user)
+	if err != nil {
+		return fmt.Errorf("DB Query failed: %v", err)
+	}
+
+	defer rows.Close()
+
+	for i := 1; rows.Next(); i++ {
+		var ap string
+		if err = rows.Scan(&ap); err != nil {
+			return fmt.Errorf("Scan grant %d failed: %v", i, err)
+		}
+		if err = cb(i, ap); err != nil {
+			if err == ErrStopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HasAp reports whether user has been granted access to the access point.
+func (s *Users) HasAp(user, ap string) (ok bool, err error) {
+	var rows *sql.Rows
+
+	rows, err = s.DB.Query("select 1 from user_ap where user = ? and ap = ?", user, ap)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bufio"
 	"io"
 	"net"
 	"net/http"
@@ -20,6 +21,48 @@ func setXForwardedFor(h http.Header, remoteAddr string) {
 	}
 }
 
+// hopHeaders is the hop-by-hop headers. They are not forwarded by proxies
+// (RFC 7230, section 6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// headerValuesContainsToken reports whether any comma separated value of
+// values is token (case insensitive).
+func headerValuesContainsToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeHopHeaders removes the hop-by-hop headers and the headers listed in
+// Connection header.
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
 func cloneHeader(h http.Header) http.Header {
 	h2 := make(http.Header, len(h))
 	for k, vv := range h {
@@ -60,3 +103,61 @@ func (fw flushWriter) Write(p []byte) (n int, err error) {
 	}
 	return
 }
+
+// statusWriter records the response status code. Works with HTTP/1.x and
+// HTTP/2 response writers.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// newStatusWriter returns the statusWriter of w and the response writer to
+// use. If w is http.Hijacker, the returned writer is http.Hijacker too.
+func newStatusWriter(w http.ResponseWriter) (*statusWriter, http.ResponseWriter) {
+	sw := &statusWriter{ResponseWriter: w}
+	if _, ok := w.(http.Hijacker); ok {
+		return sw, hijackStatusWriter{sw}
+	}
+	return sw, sw
+}
+
+// Status returns the written status code. If not written, returns 200, like
+// net/http after the handler returns.
+func (sw *statusWriter) Status() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+	return sw.status
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// hijackStatusWriter is the statusWriter of HTTP/1.x response writers.
+type hijackStatusWriter struct {
+	*statusWriter
+}
+
+func (hw hijackStatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hw.status == 0 {
+		hw.status = http.StatusSwitchingProtocols
+	}
+	return hw.ResponseWriter.(http.Hijacker).Hijack()
+}
@@ -24,8 +24,24 @@ func (srv *Server) handleWs(con *websocket.Conn) {
 	}
 }
 
+// TunnelPath is the HTTP path of token authenticated WebSocket tunnel to AP
+// services (see `xssh connect`). Other upgrade requests are proxied to load
+// balancers.
+const TunnelPath = "/.xssh/tunnel"
+
 var connectionUpgradeRegex = regexp.MustCompile("(^|.*,\\s*)upgrade($|\\s*,)")
 
 func isWebsocketRequest(req *http.Request) bool {
 	return connectionUpgradeRegex.MatchString(strings.ToLower(req.Header.Get("Connection"))) && strings.ToLower(req.Header.Get("Upgrade")) == "websocket"
 }
+
+// isUpgradeRequest reports whether the request asks for a protocol upgrade.
+func isUpgradeRequest(req *http.Request) bool {
+	return connectionUpgradeRegex.MatchString(strings.ToLower(req.Header.Get("Connection"))) && req.Header.Get("Upgrade") != ""
+}
+
+// isTunnelRequest reports whether the request is a WebSocket tunnel request.
+// The clients older than TunnelPath are identified by X-Ap header.
+func isTunnelRequest(req *http.Request) bool {
+	return isWebsocketRequest(req) && (req.URL.Path == TunnelPath || req.Header.Get("X-Ap") != "")
+}
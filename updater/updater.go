@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 
@@ -19,18 +18,17 @@ type Fetcher struct {
 	KeyFile    string
 	ServerAddr string
 	User       string
-	signer     ssh.Signer
-	URLFetcher func(url string) (f fetcher.Interface, err error)
+	// HostKeyCallback verifies the server host key. If nil, uses the
+	// default common.HostKeyConfig callback.
+	HostKeyCallback ssh.HostKeyCallback
+	signer          ssh.Signer
+	URLFetcher      func(url string) (f fetcher.Interface, err error)
 }
 
 func (f *Fetcher) Init() error {
-	buf, err := ioutil.ReadFile(common.GetKeyFile(f.KeyFile))
-	if err != nil {
-		return fmt.Errorf("load Key failed: %v", err)
-	}
-	f.signer, err = ssh.ParsePrivateKey(buf)
-	if err != nil {
-		return fmt.Errorf("parse Key failed: %v", err)
+	var err error
+	if f.signer, err = common.LoadSigner(common.GetKeyFile(f.KeyFile)); err != nil {
+		return err
 	}
 	if f.URLFetcher == nil {
 		f.URLFetcher = func(url string) (f fetcher.Interface, err error) {
@@ -87,7 +85,9 @@ func (f *Fetcher) Fetch() (r io.Reader, err error) {
 		Auth: []ssh.AuthMethod{ssh.PublicKeys(f.signer)},
 	}
 
-	sshConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	if sshConfig.HostKeyCallback = f.HostKeyCallback; sshConfig.HostKeyCallback == nil {
+		sshConfig.HostKeyCallback = (&common.HostKeyConfig{}).Callback()
+	}
 
 	client, err := ssh.Dial("tcp", f.ServerAddr, sshConfig)
 	if err != nil {